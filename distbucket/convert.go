@@ -0,0 +1,60 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// runConvert converts an input file to another format (in canonical form, with
+// all the settings filled in).
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := flags.String("from", "", "input format: yaml, json or toml (default: detected)")
+	to := flags.String("to", "yaml", "output format: yaml, json or toml")
+	outFile := flags.String("o", "", "output file (default stdout)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket convert [flags] <input-file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fromFormat, err := lib.ParseFormat(*from)
+	if err != nil {
+		return err
+	}
+	toFormat, err := lib.ParseFormat(*to)
+	if err != nil {
+		return err
+	}
+	if toFormat == lib.FormatAuto {
+		return fmt.Errorf("no output format specified")
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	in, err := lib.ParseInputFormat(string(data), fromFormat)
+	if err != nil {
+		return err
+	}
+	text, err := in.Marshal(toFormat)
+	if err != nil {
+		return err
+	}
+	if *outFile == "" {
+		_, err = os.Stdout.WriteString(text)
+		return err
+	}
+	return ioutil.WriteFile(*outFile, []byte(text), 0644)
+}
@@ -0,0 +1,76 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/emulate"
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// runEmulate runs the distributed token bucket for an input file in real time
+// (see package emulate) and writes the output, in the same form as Process. By
+// default, the emulation is compared to the simulation; inputs with variants
+// can use the "emulated" algorithm directly.
+func runEmulate(args []string) error {
+	flags := flag.NewFlagSet("emulate", flag.ExitOnError)
+	speedUp := flags.Float64("speedup", 10, "speed-up factor of the clock")
+	outFile := flags.String("o", "", "output JSON file (default stdout)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket emulate [flags] <input-file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if !(*speedUp > 0) {
+		return fmt.Errorf("invalid speed-up factor %v", *speedUp)
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	in, err := lib.ParseInput(string(data))
+	if err != nil {
+		return err
+	}
+	if len(in.Variants) == 0 {
+		in.Variants = []lib.Variant{
+			{Name: "emulated", Algorithm: "emulated"},
+			{Name: "simulated", Algorithm: "distributed"},
+		}
+	}
+	fmt.Fprintf(
+		os.Stderr, "emulating %s at %gx speed-up (about %s)\n",
+		in.Config.Timeframe, *speedUp, time.Duration(float64(in.Config.Timeframe) / *speedUp).Round(time.Millisecond),
+	)
+	res, err := lib.RunWithAlgorithms(in, map[string]lib.ExternalAlgorithm{
+		"emulated": emulate.Algorithm(*speedUp),
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range res.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w.Error())
+	}
+	write := func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res.Output())
+	}
+	if *outFile == "" {
+		return write(os.Stdout)
+	}
+	return writeFile(*outFile, write)
+}
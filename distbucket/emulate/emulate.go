@@ -0,0 +1,227 @@
+// Package emulate runs the distributed token bucket in real time: the global
+// bucket runs behind an HTTP server on localhost and each node's local bucket
+// runs in a goroutine which sends refill requests to the server. The clock is
+// the real clock, sped up by a configurable factor.
+//
+// Unlike a Simulation, the requests take time and can be processed in any
+// order, and the nodes can fall behind if the machine can't keep up; this
+// exposes concurrency and timing assumptions in the algorithm.
+package emulate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// maxRequestSize limits the size of a refill request body.
+const maxRequestSize = 4096
+
+// clock maps the real time to ticks.
+type clock struct {
+	start time.Time
+	// tick is the real duration of a tick.
+	tick     time.Duration
+	numTicks int
+}
+
+func makeClock(cfg *lib.Config, speedUp float64) clock {
+	c := clock{
+		start:    time.Now(),
+		tick:     time.Duration(float64(cfg.Tick) / speedUp),
+		numTicks: cfg.NumTicks(),
+	}
+	if c.tick <= 0 {
+		c.tick = 1
+	}
+	return c
+}
+
+// now returns the current tick.
+func (c clock) now() int {
+	tick := int(time.Since(c.start) / c.tick)
+	if tick >= c.numTicks {
+		tick = c.numTicks - 1
+	}
+	return tick
+}
+
+// waitFor sleeps until the start of the given tick.
+func (c clock) waitFor(tick int) {
+	time.Sleep(time.Until(c.start.Add(time.Duration(tick) * c.tick)))
+}
+
+// server serves refill requests on the global bucket.
+type server struct {
+	clock clock
+	mu    sync.Mutex
+	gb    *lib.GlobalBucket
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/request" {
+		http.NotFound(w, r)
+		return
+	}
+	var req lib.RefillRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.gb.AdvanceTo(s.clock.now())
+	resp, err := s.gb.Request(req)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// client sends refill requests to the server.
+type client struct {
+	url  string
+	http *http.Client
+}
+
+func (c *client) request(req lib.RefillRequest) (lib.RefillResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return lib.RefillResponse{}, err
+	}
+	httpResp, err := c.http.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return lib.RefillResponse{}, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(httpResp.Body)
+		return lib.RefillResponse{}, fmt.Errorf("refill request failed: %s: %s", httpResp.Status, bytes.TrimSpace(msg.Bytes()))
+	}
+	var resp lib.RefillResponse
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	return resp, err
+}
+
+type result struct {
+	resp lib.RefillResponse
+	err  error
+}
+
+// runNode runs the local bucket of a node until the end of the timeframe. At
+// most one refill request is in flight at any time; the response is applied at
+// the first tick after it arrives.
+func runNode(clock clock, c *client, l *lib.LocalBucket) error {
+	var inFlight *lib.RefillRequest
+	results := make(chan result, 1)
+	var err error
+	for tick := 0; tick < clock.numTicks; tick++ {
+		clock.waitFor(tick)
+		if inFlight != nil {
+			select {
+			case res := <-results:
+				if res.err != nil && err == nil {
+					err = res.err
+				}
+				l.Refill(tick, *inFlight, res.resp)
+				inFlight = nil
+			default:
+			}
+		}
+		if inFlight == nil && err == nil {
+			if req, ok := l.RefillRequest(tick); ok {
+				inFlight = &req
+				go func() {
+					resp, err := c.request(req)
+					results <- result{resp: resp, err: err}
+				}()
+			}
+		}
+		l.Tick(tick)
+	}
+	if inFlight != nil {
+		// Wait for the last request, so that it doesn't outlive the server.
+		<-results
+	}
+	return err
+}
+
+// Run emulates the distributed token bucket in real time, with the given
+// speed-up factor (e.g. with a speed-up of 10, a 60s timeframe takes 6s). The
+// results have the same form as those of an Algorithm.
+func Run(
+	cfg *lib.Config, requested lib.PerNodeData, speedUp float64,
+) (granted lib.PerNodeData, globalTokens lib.Data, _ error) {
+	if !(speedUp > 0) {
+		return nil, nil, fmt.Errorf("invalid speed-up factor %v", speedUp)
+	}
+	if len(requested) == 0 {
+		return lib.MakePerNodeData(cfg, 0), lib.ZeroData(cfg), nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &server{
+		gb: lib.NewGlobalBucket(cfg),
+	}
+	httpServer := &http.Server{Handler: s}
+	defer httpServer.Close()
+
+	c := &client{
+		url: fmt.Sprintf("http://%s/request", ln.Addr()),
+		http: &http.Client{
+			Transport: &http.Transport{MaxIdleConnsPerHost: len(requested)},
+			Timeout:   10 * time.Second,
+		},
+	}
+	locals := make([]*lib.LocalBucket, len(requested))
+	for i := range locals {
+		locals[i] = lib.NewLocalBucket(cfg, requested[i], i)
+	}
+
+	// The clock starts once everything is set up.
+	s.clock = makeClock(cfg, speedUp)
+	go httpServer.Serve(ln)
+	errs := make([]error, len(locals))
+	var wg sync.WaitGroup
+	for i := range locals {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = runNode(s.clock, c, locals[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, nil, fmt.Errorf("node %d: %v", i+1, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gb.AdvanceTo(s.clock.numTicks - 1)
+	granted = lib.MakePerNodeData(cfg, len(locals))
+	for i := range locals {
+		granted[i] = locals[i].Granted()
+	}
+	return granted, s.gb.Tokens(), nil
+}
+
+// Algorithm returns an external algorithm that runs the emulation with the
+// given speed-up factor (see lib.RunWithAlgorithms).
+func Algorithm(speedUp float64) lib.ExternalAlgorithm {
+	return func(cfg *lib.Config, requested lib.PerNodeData) (lib.PerNodeData, lib.Data, error) {
+		return Run(cfg, requested, speedUp)
+	}
+}
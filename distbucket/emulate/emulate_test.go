@@ -0,0 +1,47 @@
+package emulate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// TestRun emulates a small input at a high speed-up and compares the total
+// granted amounts with the simulation.
+func TestRun(t *testing.T) {
+	cfg := lib.DefaultConfig
+	cfg.Timeframe = 60 * time.Second
+	cfg.RatePerSec = 100
+	in := lib.NewInput(cfg,
+		lib.Node(lib.Constant(30)),
+		lib.Node(lib.Constant(50).From(20*time.Second)),
+		lib.Node(lib.Constant(80).From(40*time.Second)),
+	)
+	requested, err := in.Requested()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := func(granted lib.PerNodeData) float64 {
+		var sum float64
+		for _, v := range granted.Aggregate(&in.Config) {
+			sum += v * in.Config.Tick.Seconds()
+		}
+		return sum
+	}
+
+	granted, tokens, err := Run(&in.Config, requested, 30 /* speedUp */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(granted) != len(requested) || len(tokens) != in.Config.NumTicks() {
+		t.Fatalf("unexpected result size: %d nodes, %d ticks", len(granted), len(tokens))
+	}
+	simGranted, _ := lib.DistTokenBucket3(&in.Config, requested)
+	emulated, simulated := total(granted), total(simGranted)
+	// The emulation depends on timing, so we only expect it to be close.
+	if math.Abs(emulated-simulated) > 0.05*simulated {
+		t.Errorf("emulated total %.1f, simulated total %.1f", emulated, simulated)
+	}
+}
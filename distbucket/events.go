@@ -0,0 +1,56 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// runEvents runs the distributed token bucket on an input YAML file and writes
+// out the log of requests to the global bucket.
+func runEvents(args []string) error {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	format := flags.String("format", "jsonl", "output format: jsonl or csv")
+	outFile := flags.String("o", "", "output file (default stdout)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket events [flags] <input.yaml>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	inputYAML, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	sim, err := lib.NewSimulationFromYAML(string(inputYAML))
+	if err != nil {
+		return err
+	}
+	sim.RecordEvents()
+	for sim.Step() {
+	}
+
+	out := os.Stdout
+	if *outFile != "" {
+		if out, err = os.Create(*outFile); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	if err := sim.Events().Write(w, *format); err != nil {
+		return err
+	}
+	return w.Flush()
+}
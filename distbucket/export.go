@@ -0,0 +1,115 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+var fileNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// runExport processes an input file and writes the resulting charts in one of
+// the export formats.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String(
+		"format", "csv-long",
+		"output format: csv (one file per chart), csv-long, columnar or openmetrics",
+	)
+	outPath := flags.String("o", "", "output file (default stdout); for -format=csv, the output directory")
+	start := flags.String("start", "", "start time for the synthetic timestamps, in RFC 3339 format (default now)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket export [flags] <input-file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startTime := time.Now()
+	if *start != "" {
+		var err error
+		if startTime, err = time.Parse(time.RFC3339, *start); err != nil {
+			return err
+		}
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := lib.Process(string(data))
+	if out.Error != "" {
+		return fmt.Errorf("%s", out.Error)
+	}
+
+	if *format == "csv" {
+		if *outPath == "" {
+			return fmt.Errorf("-o is required for -format=csv")
+		}
+		if err := os.MkdirAll(*outPath, 0755); err != nil {
+			return err
+		}
+		for i := range out.Charts {
+			name := strings.Trim(fileNameRegexp.ReplaceAllString(strings.ToLower(out.Charts[i].Title), "-"), "-")
+			file := filepath.Join(*outPath, fmt.Sprintf("%02d-%s.csv", i+1, name))
+			err := writeFile(file, func(w io.Writer) error {
+				return out.WriteChartCSV(w, &out.Charts[i])
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println(file)
+		}
+		return nil
+	}
+
+	var write func(w io.Writer) error
+	switch *format {
+	case "csv-long":
+		write = out.WriteLongCSV
+	case "columnar":
+		write = out.WriteColumnar
+	case "openmetrics":
+		write = func(w io.Writer) error {
+			return out.WriteOpenMetrics(w, startTime)
+		}
+	default:
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+	if *outPath == "" {
+		return write(os.Stdout)
+	}
+	return writeFile(*outPath, write)
+}
+
+// writeFile creates a file and writes it using the given function.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
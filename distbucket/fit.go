@@ -0,0 +1,184 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"gopkg.in/yaml.v2"
+)
+
+// runFit approximates recorded per-node rates with function terms (see
+// lib.FitFuncDesc) and writes an input YAML file with the fitted nodes. Only
+// the fitted parameters end up in the output (not the column names or the
+// data), so it can be shared; the config only contains the timeframe and the
+// tick, so the other settings use the defaults.
+func runFit(args []string) error {
+	flags := flag.NewFlagSet("fit", flag.ExitOnError)
+	tick := flags.Duration("tick", lib.DefaultConfig.Tick, "simulation tick; the data is resampled to this resolution")
+	opts := lib.DefaultFitOptions
+	flags.IntVar(&opts.MaxRamps, "max-ramps", opts.MaxRamps, "maximum number of ramp terms per node")
+	flags.IntVar(&opts.MaxSines, "max-sines", opts.MaxSines, "maximum number of sine terms per node")
+	flags.IntVar(&opts.MaxBursts, "max-bursts", opts.MaxBursts, "maximum number of gaussian terms per node")
+	flags.Float64Var(&opts.MinGain, "min-gain", opts.MinGain, "minimum fraction of the variance that a term must explain")
+	flags.Float64Var(
+		&opts.Significance, "significance", opts.Significance,
+		"minimum reduction of the squared error for a term, relative to the residual noise",
+	)
+	noNoise := flags.Bool("no-noise", false, "don't add noise terms for the residuals")
+	outFile := flags.String("o", "", "output file (default stdout)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket fit [flags] <data.csv>\n\n")
+		fmt.Fprintf(os.Stderr, "The CSV file has a header row, a time column (in seconds) and a rate\n")
+		fmt.Fprintf(os.Stderr, "column for each node (e.g. the output of 'distbucket export -format=csv').\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *tick <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	opts.Noise = !*noNoise
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	series, err := readSeriesCSV(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", flags.Arg(0), err)
+	}
+
+	cfg := lib.DefaultConfig
+	cfg.Tick = *tick
+	// The timeframe starts at the first sample of any column.
+	start, end := series[0].times[0], series[0].times[0]
+	for _, s := range series {
+		start = math.Min(start, s.times[0])
+		end = math.Max(end, s.times[len(s.times)-1])
+	}
+	span := end - start
+	cfg.Timeframe = cfg.Tick * time.Duration(1+cfg.TickForTime(time.Duration(span*float64(time.Second))))
+
+	in := lib.NewInput(cfg)
+	var header strings.Builder
+	fmt.Fprintf(&header, "# Fitted with distbucket fit (tick %s).\n", cfg.Tick)
+	for i, s := range series {
+		for j := range s.times {
+			s.times[j] -= start
+		}
+		res, err := lib.FitFuncDesc(&cfg, lib.ResampleData(&cfg, s.times, s.values), opts)
+		if err != nil {
+			return fmt.Errorf("%s: node %d: %v", flags.Arg(0), i+1, err)
+		}
+		in.Nodes = append(in.Nodes, res.Desc)
+		fmt.Fprintf(&header, "# node %d: RMSE %.4g (standard deviation %.4g)\n", i+1, res.RMSE, res.StdDev)
+	}
+	text, err := fitYAML(in)
+	if err != nil {
+		return err
+	}
+	text = header.String() + text
+	if *outFile == "" {
+		_, err = os.Stdout.WriteString(text)
+		return err
+	}
+	return ioutil.WriteFile(*outFile, []byte(text), 0644)
+}
+
+// fitYAML returns the input in YAML form, with only the timeframe and the tick
+// in the config section.
+func fitYAML(in lib.Input) (string, error) {
+	text, err := in.YAML()
+	if err != nil {
+		return "", err
+	}
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(text), &tree); err != nil {
+		return "", err
+	}
+	for i := range tree {
+		if tree[i].Key != "config" {
+			continue
+		}
+		var cfg yaml.MapSlice
+		for _, item := range tree[i].Value.(yaml.MapSlice) {
+			if item.Key == "timeframe" || item.Key == "tick" {
+				cfg = append(cfg, item)
+			}
+		}
+		tree[i].Value = cfg
+	}
+	out, err := yaml.Marshal(tree)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// series contains the samples in a CSV column (with increasing times).
+type series struct {
+	times  []float64
+	values []float64
+}
+
+// readSeriesCSV reads the CSV data for runFit. Empty cells are skipped.
+func readSeriesCSV(r io.Reader) ([]series, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("expected a time column and at least one rate column")
+	}
+	res := make([]series, len(header)-1)
+	// The header is on the first line.
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		if err != nil || math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("line %d: invalid time '%s'", line, record[0])
+		}
+		for i, cell := range record[1:] {
+			if cell = strings.TrimSpace(cell); cell == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("line %d: invalid value '%s' in column '%s'", line, cell, header[i+1])
+			}
+			res[i].times = append(res[i].times, t)
+			res[i].values = append(res[i].values, v)
+		}
+	}
+	for i, s := range res {
+		if len(s.times) == 0 {
+			return nil, fmt.Errorf("no values in column '%s'", header[i+1])
+		}
+		for j := 1; j < len(s.times); j++ {
+			if s.times[j] <= s.times[j-1] {
+				return nil, fmt.Errorf("times in column '%s' are not increasing", header[i+1])
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,68 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// runFuzz generates random inputs, starting at a given seed, and checks the
+// token bucket invariants. Failing inputs are minimized and written out as
+// YAML files that can be loaded into the UI.
+func runFuzz(args []string) error {
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	seed := flags.Int64("seed", time.Now().UnixNano(), "first random seed")
+	iterations := flags.Int("n", 1000, "number of inputs to try")
+	outDir := flags.String("out", "fuzz-failures", "directory for minimized failing inputs")
+	maxFailures := flags.Int("max-failures", 10, "stop after this many failures")
+	flags.Parse(args)
+
+	// The generated inputs are valid, so validation errors are failures too.
+	// During minimization, they don't count as the same failure.
+	check := lib.CheckInput
+
+	var failures int
+	for i := 0; i < *iterations && failures < *maxFailures; i++ {
+		s := *seed + int64(i)
+		in := lib.NewWorkloadGen(s).Input()
+		err := check(in)
+		if err == nil {
+			continue
+		}
+		failures++
+		fmt.Printf("seed %d: %v\n", s, err)
+
+		in = lib.MinimizeInput(in, check)
+		err = check(in)
+		yaml, yamlErr := in.YAML()
+		if yamlErr != nil {
+			return yamlErr
+		}
+		// Verify that the failure reproduces from the YAML.
+		if parsed, parseErr := lib.ParseInput(yaml); parseErr != nil || check(parsed) == nil {
+			fmt.Printf("  warning: minimized YAML does not reproduce the failure\n")
+		}
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(*outDir, fmt.Sprintf("seed-%d.yaml", s))
+		contents := fmt.Sprintf("# seed: %d\n# error: %v\n%s", s, err, yaml)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("  minimized: %s\n", path)
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d failures", failures)
+	}
+	fmt.Printf("%d inputs passed\n", *iterations)
+	return nil
+}
@@ -1,6 +1,6 @@
 module github.com/RaduBerinde/raduberinde.github.io/distbucket
 
-go 1.16
+go 1.21
 
 require (
 	github.com/gopherjs/gopherjs v0.0.0-20210503212227-fb464eba2686
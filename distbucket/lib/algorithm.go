@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// Algorithm is a token bucket implementation: given the requested rate for
+// each node, it returns the granted rate for each node and the amount of
+// tokens in the (global) bucket at each tick.
+type Algorithm func(cfg *Config, requested PerNodeData) (granted PerNodeData, tokens Data)
+
+// ExternalAlgorithm is a token bucket implementation outside this package,
+// which can fail (e.g. an emulation; see RunWithAlgorithms). Otherwise, it is
+// the same as an Algorithm.
+type ExternalAlgorithm func(cfg *Config, requested PerNodeData) (granted PerNodeData, tokens Data, _ error)
+
+// DefaultAlgorithm is the algorithm used when none is specified.
+const DefaultAlgorithm = "distributed"
+
+// Algorithms contains the available algorithms, by name.
+var Algorithms = map[string]Algorithm{
+	"distributed": DistTokenBucket3,
+	"ideal":       TokenBucket,
+}
+
+// algorithmNames returns the names of all the algorithms (including the given
+// external ones), for error messages.
+func algorithmNames(external map[string]ExternalAlgorithm) string {
+	var names []string
+	for name := range Algorithms {
+		names = append(names, name)
+	}
+	for name := range external {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
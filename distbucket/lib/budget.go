@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file implements tenant spending budgets: on top of the rate limit, a
+// tenant can have a budget of RUs for each billing period (see Config.Budget).
+// The global bucket (or the ideal token bucket) enforces the budget based on
+// the tokens it grants; once the budget is exhausted, the post-exhaustion
+// policy applies until the end of the period.
+
+// Budget policies.
+const (
+	// BudgetHardStop stops granting tokens.
+	BudgetHardStop = "hard_stop"
+	// BudgetReducedRate refills the bucket at BudgetReducedRate instead of
+	// RatePerSec.
+	BudgetReducedRate = "reduced_rate"
+	// BudgetBurstOnly stops refilling the bucket; the tokens that are already in
+	// the bucket can still be used.
+	BudgetBurstOnly = "burst_only"
+)
+
+var budgetPolicies = []string{BudgetHardStop, BudgetReducedRate, BudgetBurstOnly}
+
+func validBudgetPolicy(policy string) bool {
+	for _, p := range budgetPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// budgetPeriodTicks returns the number of ticks in a billing period.
+func (c *Config) budgetPeriodTicks() int {
+	if n := c.TickForTime(c.BudgetPeriod); n > 0 && n < c.NumTicks() {
+		return n
+	}
+	return c.NumTicks()
+}
+
+// budget tracks the remaining budget for the current billing period.
+type budget struct {
+	cfg       *Config
+	remaining float64
+}
+
+// newBudget returns nil if the config doesn't have a budget.
+func newBudget(cfg *Config) *budget {
+	if cfg.Budget == 0 {
+		return nil
+	}
+	return &budget{cfg: cfg, remaining: cfg.Budget}
+}
+
+// tick must be called at the start of each tick; it resets the budget at the
+// start of each billing period (b can be nil).
+func (b *budget) tick(now int) {
+	if b != nil && now%b.cfg.budgetPeriodTicks() == 0 {
+		b.remaining = b.cfg.Budget
+	}
+}
+
+func (b *budget) exhausted() bool {
+	return b.remaining <= 0
+}
+
+// refillRate returns the rate at which the bucket is refilled (b can be nil).
+func (b *budget) refillRate(cfg *Config) float64 {
+	if b == nil || !b.exhausted() {
+		return cfg.RatePerSec
+	}
+	switch cfg.BudgetPolicy {
+	case BudgetReducedRate:
+		// The reduced rate can't be higher than the regular rate.
+		return math.Min(cfg.BudgetReducedRate, cfg.RatePerSec)
+	default:
+		return 0
+	}
+}
+
+// limit returns how much of the given amount can be granted (b can be nil).
+func (b *budget) limit(amount float64) float64 {
+	if b == nil || b.cfg.BudgetPolicy != BudgetHardStop || amount <= b.remaining {
+		return amount
+	}
+	if b.remaining < 0 {
+		return 0
+	}
+	return b.remaining
+}
+
+// charge records that the given amount was granted (b can be nil).
+func (b *budget) charge(amount float64) {
+	if b != nil {
+		b.remaining -= amount
+	}
+}
+
+// budgetExhaustedFraction is the fraction of the budget under which we consider
+// it exhausted in budgetChart, to allow for floating point error.
+const budgetExhaustedFraction = 1e-3
+
+// budgetChart returns a chart with the remaining budget for each run that has
+// a budget, based on the granted RUs, with markers at the moments the budget
+// is exhausted. Note that the global bucket enforces the budget based on the
+// tokens it hands out to the nodes, which can be ahead of the granted RUs (and
+// tokens handed out at the end of a period can be used in the next one).
+func budgetChart(names []string, runs []*run) Chart {
+	c := Chart{
+		Title: "Remaining budget",
+		Units: []Unit{{Name: "RU"}},
+	}
+	for i, r := range runs {
+		cfg := r.cfg
+		if cfg.Budget == 0 {
+			continue
+		}
+		periodTicks := cfg.budgetPeriodTicks()
+		remaining := ZeroData(cfg)
+		threshold := cfg.Budget * budgetExhaustedFraction
+		var rem float64
+		for t, v := range r.granted.Aggregate(cfg) {
+			if t%periodTicks == 0 {
+				rem = cfg.Budget
+			}
+			wasExhausted := rem <= threshold
+			rem -= v * cfg.Tick.Seconds()
+			remaining[t] = rem
+			if rem <= threshold && !wasExhausted {
+				c.Markers = append(c.Markers, Marker{
+					Time:   cfg.TimeForTick(t).Seconds(),
+					Series: names[i],
+					Label:  fmt.Sprintf("%s: budget exhausted (%s)", names[i], cfg.BudgetPolicy),
+				})
+			}
+		}
+		c.Series = append(c.Series, Series{Name: names[i], Unit: "RU", Width: 1, Data: remaining})
+	}
+	return c
+}
+
+// anyBudget returns true if any of the runs has a budget.
+func anyBudget(runs []*run) bool {
+	for _, r := range runs {
+		if r.cfg.Budget > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package lib
+
+import "time"
+
+// This file contains helpers for constructing inputs in Go (for use with Run),
+// as an alternative to YAML. For example:
+//
+//   in := NewInput(DefaultConfig,
+//     Node(Constant(100), Noise(50, 10)),
+//     Node(Constant(200).From(5*time.Minute).For(time.Minute)),
+//   )
+//   res, err := Run(in)
+
+// NewInput returns an input with the given config and nodes.
+func NewInput(cfg Config, nodes ...FuncDesc) Input {
+	return Input{
+		Version: CurrentVersion,
+		Config:  cfg,
+		Nodes:   nodes,
+	}
+}
+
+// Node returns a node function which is the sum of the given terms.
+func Node(terms ...FuncTerm) FuncDesc {
+	return FuncDesc{Terms: terms}
+}
+
+// Constant returns a term with a constant value.
+func Constant(value float64) FuncTerm {
+	return FuncTerm{Type: "constant", Value: value}
+}
+
+// Ramp returns a term which increases linearly from 0 to delta over the
+// duration of the term (or until the end of the timeframe) and stays at delta
+// afterwards.
+func Ramp(delta float64) FuncTerm {
+	return FuncTerm{Type: "ramp", Delta: delta}
+}
+
+// Sine returns a term which oscillates between 0 and amplitude.
+func Sine(amplitude float64, period time.Duration) FuncTerm {
+	return FuncTerm{Type: "sine", Amplitude: amplitude, Period: period.Seconds()}
+}
+
+// Gaussian returns a bell-shaped term with the given peak; the duration is
+// the width at 1% of the peak.
+func Gaussian(amplitude float64, duration time.Duration) FuncTerm {
+	return FuncTerm{Type: "gaussian", Amplitude: amplitude, Duration: duration.Seconds()}
+}
+
+// Noise returns a random noise term; the noise changes smoothly over the given
+// number of ticks.
+func Noise(amplitude float64, smoothness int) FuncTerm {
+	return FuncTerm{Type: "noise", Amplitude: amplitude, Smoothness: smoothness}
+}
+
+// From returns the term with the given start time.
+func (f FuncTerm) From(start time.Duration) FuncTerm {
+	f.Start = start.Seconds()
+	return f
+}
+
+// For returns the term with the given duration.
+func (f FuncTerm) For(duration time.Duration) FuncTerm {
+	f.Duration = duration.Seconds()
+	return f
+}
+
+// WithPhase returns the (sine) term with the given phase offset.
+func (f FuncTerm) WithPhase(phase time.Duration) FuncTerm {
+	f.Phase = phase.Seconds()
+	return f
+}
+
+// WithOperation returns the term as the rate of the given operation (e.g.
+// "write_bytes"), instead of a rate of RUs; see FuncTerm.Operation.
+func (f FuncTerm) WithOperation(operation string) FuncTerm {
+	f.Operation = operation
+	return f
+}
+
+// WithSeed returns the (noise) term with the given random seed.
+func (f FuncTerm) WithSeed(seed int64) FuncTerm {
+	f.Seed = seed
+	return f
+}
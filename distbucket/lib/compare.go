@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Variant is a named set of settings, used to compare configurations (or
+// algorithms) on the same workload.
+type Variant struct {
+	Name string
+	// Algorithm is one of the keys in Algorithms (or one of the external
+	// algorithms passed to RunWithAlgorithms); defaults to DefaultAlgorithm.
+	Algorithm string `yaml:",omitempty"`
+	// Config contains settings that override the input config, using the same
+	// keys. The timeframe, the tick and the cost model can't be overridden.
+	Config map[string]interface{} `yaml:",omitempty"`
+}
+
+// variantConfigs returns the variants, with the default name and algorithm
+// filled in, and the configuration for each variant. The input is not
+// modified.
+func (in *Input) variantConfigs(external map[string]ExternalAlgorithm) ([]Variant, []Config, InputErrors) {
+	var errs InputErrors
+	variants := append([]Variant(nil), in.Variants...)
+	configs := make([]Config, len(variants))
+	names := make(map[string]bool)
+	for i := range variants {
+		v := &variants[i]
+		path := fmt.Sprintf("variants[%d]", i)
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("variant %d", i+1)
+		}
+		if names[v.Name] {
+			errs.Errorf(path+".name", "duplicate variant name '%s'", v.Name)
+		}
+		names[v.Name] = true
+		if v.Algorithm == "" {
+			v.Algorithm = DefaultAlgorithm
+		}
+		if Algorithms[v.Algorithm] == nil && external[v.Algorithm] == nil {
+			errs.Errorf(
+				path+".algorithm", "unknown algorithm '%s' (must be one of: %s)", v.Algorithm, algorithmNames(external),
+			)
+		}
+		// The requested rates (which depend on the cost model) are the same for
+		// all the variants.
+		for _, key := range append([]string{"timeframe", "tick"}, costModelConfigKeys...) {
+			if _, ok := v.Config[key]; ok {
+				errs.Errorf(path+".config."+key, "can't be overridden in a variant")
+			}
+		}
+
+		cfg := in.Config
+		// The durations are already set; clear the seconds fields so that
+		// overriding only a duration works.
+		cfg.TargetRefillPeriodSecs = 0
+		cfg.BacklogTimeScaleSecs = 0
+		// Each override is applied separately, so that any problems are
+		// reported for its key.
+		keys := make([]string, 0, len(v.Config))
+		for key := range v.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		valid := true
+		for _, key := range keys {
+			keyPath := path + ".config." + key
+			if l, ok := findLegacyKey(key); ok {
+				// Legacy keys are renamed by migrateInput, for versions in which
+				// they are valid.
+				errs.Errorf(keyPath, "not supported in version %d; use %s instead", CurrentVersion, l.replacement)
+				valid = false
+				continue
+			}
+			override, err := yaml.Marshal(map[string]interface{}{key: v.Config[key]})
+			if err == nil {
+				err = yaml.UnmarshalStrict(override, &cfg)
+			}
+			if err != nil {
+				// The line numbers refer to the override, not the input.
+				for _, e := range yamlErrors(string(override), err) {
+					errs.Errorf(keyPath, "%s", e.Message)
+				}
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+		cfg.applySecs()
+		errs = append(errs, cfg.Validate().withPrefix(path+".config")...)
+		configs[i] = cfg
+	}
+	return variants, configs, errs
+}
+
+// compareCharts generates charts that overlay the results of the runs (one for
+// each variant), along with charts of the differences from the first run.
+func compareCharts(
+	cfg *Config, names []string, runs []*run, requested PerNodeData,
+) (charts []Chart, table Table) {
+	type quantity struct {
+		title string
+		unit  string
+		data  func(r *run) Data
+	}
+	quantities := []quantity{
+		{
+			title: "Granted aggregate",
+			unit:  "RU/s",
+			data: func(r *run) Data {
+				return r.granted.Aggregate(r.cfg)
+			},
+		},
+		{
+			title: "Global tokens",
+			unit:  "RU",
+			data: func(r *run) Data {
+				return r.tokens
+			},
+		},
+		{
+			title: "Total granted",
+			unit:  "RU",
+			data: func(r *run) Data {
+				return r.granted.Aggregate(r.cfg).Cumulative(r.cfg)
+			},
+		},
+	}
+
+	for _, q := range quantities {
+		series := make([]Series, len(runs))
+		for i, r := range runs {
+			series[i] = Series{
+				Name:  names[i],
+				Unit:  q.unit,
+				Width: 1,
+				Data:  q.data(r),
+			}
+		}
+		if q.unit == "RU/s" {
+			series = append(series, Series{
+				Name:  "requested",
+				Unit:  q.unit,
+				Width: 0.5,
+				Data:  requested.Aggregate(cfg),
+			})
+		}
+		charts = append(charts, Chart{
+			Title:  q.title,
+			Units:  []Unit{{Name: q.unit}},
+			Series: series,
+		})
+	}
+
+	if anyBudget(runs) {
+		charts = append(charts, budgetChart(names, runs))
+	}
+
+	if len(runs) > 1 {
+		for _, q := range quantities {
+			base := q.data(runs[0])
+			var series []Series
+			for i := 1; i < len(runs); i++ {
+				series = append(series, Series{
+					Name:  names[i],
+					Unit:  q.unit,
+					Width: 1,
+					Data:  q.data(runs[i]).Diff(cfg, base),
+				})
+			}
+			charts = append(charts, Chart{
+				Title:  fmt.Sprintf("%s difference (vs %s)", q.title, names[0]),
+				Units:  []Unit{{Name: q.unit}},
+				Series: series,
+			})
+		}
+	}
+
+	return charts, metricsTable(names, runs, true /* withDeltas */)
+}
@@ -35,8 +35,46 @@ type Config struct {
 	BacklogTimeScaleSecs float64       `yaml:"backlog_time_scale_secs"`
 	BacklogFactorLog10   float64       `yaml:"backlog_factor_log_10"`
 
+	// Cost model: the cost (in RUs) of the operations in terms that specify an
+	// operation (see FuncTerm.Operation).
+	RUPerReadBatch  float64 `yaml:"ru_per_read_batch"`
+	RUPerReadMiB    float64 `yaml:"ru_per_read_mib"`
+	RUPerWriteBatch float64 `yaml:"ru_per_write_batch"`
+	RUPerWriteMiB   float64 `yaml:"ru_per_write_mib"`
+	RUPerSQLCPUSec  float64 `yaml:"ru_per_sql_cpu_sec"`
+
+	// Estimation errors: the work granted at each tick actually consumes
+	// (1+e) times the granted amount, where e is random (with the given mean,
+	// standard deviation and distribution); the difference is debited or
+	// refunded after CorrectionLag (see corrections).
+	EstimateErrorMean   float64       `yaml:"estimate_error_mean"`
+	EstimateErrorStdDev float64       `yaml:"estimate_error_stddev"`
+	EstimateErrorDist   string        `yaml:"estimate_error_dist"`
+	CorrectionLag       time.Duration `yaml:"correction_lag"`
+
+	// Spending budget: if Budget is set, the tenant can use at most Budget RUs
+	// in each BudgetPeriod (or in the entire timeframe, if not set); once the
+	// budget is exhausted, the BudgetPolicy applies (see budget).
+	Budget            float64       `yaml:"budget"`
+	BudgetPeriod      time.Duration `yaml:"budget_period"`
+	BudgetPolicy      string        `yaml:"budget_policy"`
+	BudgetReducedRate float64       `yaml:"budget_reduced_rate"`
+
+	// Global bucket capacity model: if any of GlobalServiceRate, GlobalLatency
+	// or GlobalConflictProb is set, the requests to the global bucket are
+	// queued and processed with a finite throughput, and the responses are
+	// delayed (see globalServer). Otherwise, the requests are instant.
+	GlobalServiceRate  float64       `yaml:"global_service_rate"`
+	GlobalLatency      time.Duration `yaml:"global_latency"`
+	GlobalConflictProb float64       `yaml:"global_conflict_prob"`
+	GlobalRetryBackoff time.Duration `yaml:"global_retry_backoff"`
+	GlobalMaxRetries   int           `yaml:"global_max_retries"`
+
 	// Misc settings.
 	Smoothing bool
+
+	// legacy contains settings from older input versions (see migrateInput).
+	legacy legacySettings
 }
 
 func (c Config) NumTicks() int {
@@ -59,6 +97,24 @@ func (c Config) TimeAxis() []float64 {
 	return res
 }
 
+// applySecs overrides the durations that can also be specified as (floating
+// point) seconds, if the latter are set.
+func (c *Config) applySecs() {
+	if c.TargetRefillPeriodSecs != 0 {
+		c.TargetRefillPeriod = time.Duration(c.TargetRefillPeriodSecs * float64(time.Second))
+	}
+	if c.BacklogTimeScaleSecs != 0 {
+		c.BacklogTimeScale = time.Duration(c.BacklogTimeScaleSecs * float64(time.Second))
+	}
+}
+
+// setSecs is the inverse of applySecs: it sets the seconds fields from the
+// durations.
+func (c *Config) setSecs() {
+	c.TargetRefillPeriodSecs = c.TargetRefillPeriod.Seconds()
+	c.BacklogTimeScaleSecs = c.BacklogTimeScale.Seconds()
+}
+
 var DefaultConfig = Config{
 	Timeframe: 900 * time.Second,
 	Tick:      100 * time.Millisecond,
@@ -77,4 +133,19 @@ var DefaultConfig = Config{
 	EWMAFactor:         0.5,
 	BacklogTimeScale:   10 * time.Second,
 	BacklogFactorLog10: -2,
+
+	RUPerReadBatch:  0.5,
+	RUPerReadMiB:    16,
+	RUPerWriteBatch: 1,
+	RUPerWriteMiB:   1024,
+	RUPerSQLCPUSec:  1000.0 / 3,
+
+	EstimateErrorDist: "normal",
+	CorrectionLag:     1 * time.Second,
+
+	BudgetPolicy:      BudgetHardStop,
+	BudgetReducedRate: 10,
+
+	GlobalRetryBackoff: 200 * time.Millisecond,
+	GlobalMaxRetries:   5,
 }
@@ -0,0 +1,455 @@
+package lib
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// ConfigField describes a Config setting: its valid range and how it is
+// presented in the UI.
+type ConfigField struct {
+	// Key is the YAML key; it is also the id of the UI slider.
+	Key   string
+	Label string
+	// Duration is set if the value is a duration in the input (e.g. "2s"; a
+	// bare number is in nanoseconds). The ranges and the default are always in
+	// seconds.
+	Duration bool
+
+	// Min and Max define the valid range (inclusive, unless MinExclusive is
+	// set).
+	Min          float64
+	MinExclusive bool
+	Max          float64
+
+	// Group is the UI section that contains the slider for this setting; empty
+	// if the setting has no slider.
+	Group      string
+	SliderMin  float64
+	SliderMax  float64
+	SliderStep float64
+	// SliderInitial is the initial value of the slider; it is the same as
+	// Default unless it is set in the schema.
+	SliderInitial float64
+	// Default is the value in DefaultConfig.
+	Default float64
+}
+
+// Slider groups.
+const (
+	GroupBucket = "bucket"
+	GroupKnobs  = "knobs"
+)
+
+// maxReasonableTicks is the number of ticks above which we warn that the
+// simulation will be slow.
+const maxReasonableTicks = 1000000
+
+var configSchema = []ConfigField{
+	{
+		Key:          "timeframe",
+		Label:        "Timeframe (duration, e.g. 15m)",
+		Duration:     true,
+		Min:          0,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+	},
+	{
+		Key:          "tick",
+		Label:        "Tick (duration, e.g. 100ms)",
+		Duration:     true,
+		Min:          0,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+	},
+	{
+		Key:        "rate_per_sec",
+		Label:      "Refill rate (RU/s)",
+		Min:        0,
+		Max:        math.Inf(1),
+		Group:      GroupBucket,
+		SliderMin:  1,
+		SliderMax:  1000,
+		SliderStep: 1,
+	},
+	{
+		Key:        "initial_burst",
+		Label:      "Initial Burst (RU)",
+		Min:        0,
+		Max:        math.Inf(1),
+		Group:      GroupBucket,
+		SliderMin:  0,
+		SliderMax:  50000,
+		SliderStep: 1,
+	},
+	{
+		Key:           "max_burst",
+		Label:         "Max Burst (RU)",
+		Min:           0,
+		Max:           math.Inf(1),
+		Group:         GroupBucket,
+		SliderMin:     1000,
+		SliderMax:     100000,
+		SliderStep:    1,
+		SliderInitial: 10000,
+	},
+	{
+		Key:          "target_refill_period_secs",
+		Label:        "Target global request period (s)",
+		Min:          0,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+		Group:        GroupKnobs,
+		SliderMin:    2,
+		SliderMax:    100,
+		SliderStep:   1,
+	},
+	{
+		Key:        "ewma_factor",
+		Label:      "EWMA factor",
+		Min:        0,
+		Max:        1,
+		Group:      GroupKnobs,
+		SliderMin:  0,
+		SliderMax:  1,
+		SliderStep: 0.01,
+	},
+	{
+		Key:          "backlog_time_scale_secs",
+		Label:        "Backlog time scale (s)",
+		Min:          0,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+		Group:        GroupKnobs,
+		SliderMin:    1,
+		SliderMax:    100,
+		SliderStep:   1,
+	},
+	{
+		Key:        "backlog_factor_log_10",
+		Label:      "Backlog factor (log10)",
+		Min:        -30,
+		Max:        30,
+		Group:      GroupKnobs,
+		SliderMin:  -10,
+		SliderMax:  10,
+		SliderStep: 1,
+	},
+	{
+		Key:          "initial_refill_amount",
+		Label:        "Initial refill amount (RUs)",
+		Min:          0,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+		Group:        GroupKnobs,
+		SliderMin:    10,
+		SliderMax:    10000,
+		SliderStep:   1,
+	},
+	{
+		Key:        "min_refill_amount",
+		Label:      "Min refill amount (RUs)",
+		Min:        0,
+		Max:        math.Inf(1),
+		Group:      GroupKnobs,
+		SliderMin:  10,
+		SliderMax:  1000,
+		SliderStep: 1,
+	},
+	{
+		Key:          "max_refill_amount",
+		Label:        "Max refill amount (RUs)",
+		Min:          0,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+		Group:        GroupKnobs,
+		SliderMin:    100,
+		SliderMax:    100000,
+		SliderStep:   1,
+	},
+	{
+		Key:   "refill_fraction",
+		Label: "Refill fraction",
+		Min:   0,
+		Max:   1,
+	},
+	{
+		Key:      "pre_request_time",
+		Label:    "Pre-request time (duration, e.g. 1s)",
+		Duration: true,
+		Min:      0,
+		Max:      math.Inf(1),
+	},
+	{
+		Key:   "budget",
+		Label: "Budget per period (RU, 0 for none)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:      "budget_period",
+		Label:    "Budget period (duration, e.g. 1m; 0 for the timeframe)",
+		Duration: true,
+		Min:      0,
+		Max:      math.Inf(1),
+	},
+	{
+		Key:   "budget_reduced_rate",
+		Label: "Rate after the budget is exhausted (RU/s)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:          "estimate_error_mean",
+		Label:        "Estimation error mean",
+		Min:          -1,
+		MinExclusive: true,
+		Max:          math.Inf(1),
+	},
+	{
+		Key:   "estimate_error_stddev",
+		Label: "Estimation error standard deviation",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:      "correction_lag",
+		Label:    "Correction lag (duration, e.g. 1s)",
+		Duration: true,
+		Min:      0,
+		Max:      math.Inf(1),
+	},
+	{
+		Key:   "global_service_rate",
+		Label: "Global bucket service rate (req/s, 0 for unlimited)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:      "global_latency",
+		Label:    "Global bucket request latency (duration, e.g. 50ms)",
+		Duration: true,
+		Min:      0,
+		Max:      math.Inf(1),
+	},
+	{
+		Key:   "global_conflict_prob",
+		Label: "Global bucket conflict probability (per concurrent request)",
+		Min:   0,
+		Max:   1,
+	},
+	{
+		Key:      "global_retry_backoff",
+		Label:    "Global bucket retry backoff (duration, e.g. 100ms)",
+		Duration: true,
+		Min:      0,
+		Max:      math.Inf(1),
+	},
+	{
+		Key:   "global_max_retries",
+		Label: "Global bucket max retries",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:   "ru_per_read_batch",
+		Label: "Read batch cost (RU)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:   "ru_per_read_mib",
+		Label: "Read cost per MiB (RU)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:   "ru_per_write_batch",
+		Label: "Write batch cost (RU)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:   "ru_per_write_mib",
+		Label: "Write cost per MiB (RU)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+	{
+		Key:   "ru_per_sql_cpu_sec",
+		Label: "SQL CPU cost per second (RU)",
+		Min:   0,
+		Max:   math.Inf(1),
+	},
+}
+
+func init() {
+	for i := range configSchema {
+		f := &configSchema[i]
+		f.Default, _ = DefaultConfig.Get(f.Key)
+		if f.Group != "" && f.SliderInitial == 0 {
+			f.SliderInitial = f.Default
+		}
+	}
+}
+
+// ConfigSchema returns the descriptions of all the Config settings.
+func ConfigSchema() []ConfigField {
+	return configSchema
+}
+
+// Get returns the value of the setting with the given key (see ConfigField);
+// durations are returned in seconds.
+func (c *Config) Get(key string) (value float64, ok bool) {
+	switch key {
+	case "timeframe":
+		return c.Timeframe.Seconds(), true
+	case "tick":
+		return c.Tick.Seconds(), true
+	case "rate_per_sec":
+		return c.RatePerSec, true
+	case "initial_burst":
+		return c.InitialBurst, true
+	case "max_burst":
+		return c.MaxBurst, true
+	case "target_refill_period_secs":
+		return c.TargetRefillPeriod.Seconds(), true
+	case "initial_refill_amount":
+		return c.InitialRefillAmount, true
+	case "min_refill_amount":
+		return c.MinRefillAmount, true
+	case "max_refill_amount":
+		return c.MaxRefillAmount, true
+	case "refill_fraction":
+		return c.RefillFraction, true
+	case "pre_request_time":
+		return c.PreRequestTime.Seconds(), true
+	case "ewma_factor":
+		return c.EWMAFactor, true
+	case "backlog_time_scale_secs":
+		return c.BacklogTimeScale.Seconds(), true
+	case "backlog_factor_log_10":
+		return c.BacklogFactorLog10, true
+	case "budget":
+		return c.Budget, true
+	case "budget_period":
+		return c.BudgetPeriod.Seconds(), true
+	case "budget_reduced_rate":
+		return c.BudgetReducedRate, true
+	case "estimate_error_mean":
+		return c.EstimateErrorMean, true
+	case "estimate_error_stddev":
+		return c.EstimateErrorStdDev, true
+	case "correction_lag":
+		return c.CorrectionLag.Seconds(), true
+	case "global_service_rate":
+		return c.GlobalServiceRate, true
+	case "global_latency":
+		return c.GlobalLatency.Seconds(), true
+	case "global_conflict_prob":
+		return c.GlobalConflictProb, true
+	case "global_retry_backoff":
+		return c.GlobalRetryBackoff.Seconds(), true
+	case "global_max_retries":
+		return float64(c.GlobalMaxRetries), true
+	case "ru_per_read_batch":
+		return c.RUPerReadBatch, true
+	case "ru_per_read_mib":
+		return c.RUPerReadMiB, true
+	case "ru_per_write_batch":
+		return c.RUPerWriteBatch, true
+	case "ru_per_write_mib":
+		return c.RUPerWriteMiB, true
+	case "ru_per_sql_cpu_sec":
+		return c.RUPerSQLCPUSec, true
+	default:
+		return 0, false
+	}
+}
+
+// Validate checks the settings against the ranges in ConfigSchema, along with
+// some rules that involve multiple settings. The paths of the returned errors
+// are relative to the config.
+func (c *Config) Validate() InputErrors {
+	var errs InputErrors
+	for _, f := range configSchema {
+		v, _ := c.Get(f.Key)
+		// format shows durations in the same form as the input.
+		format := func(x float64) interface{} {
+			if f.Duration && !math.IsInf(x, 0) {
+				return time.Duration(x * float64(time.Second))
+			}
+			return x
+		}
+		switch {
+		case math.IsNaN(v):
+			errs.Errorf(f.Key, "invalid value %v", v)
+		case f.MinExclusive && v <= f.Min:
+			errs.Errorf(f.Key, "%v must be greater than %v", format(v), format(f.Min))
+		case v < f.Min:
+			errs.Errorf(f.Key, "%v must be at least %v", format(v), format(f.Min))
+		case v > f.Max:
+			errs.Errorf(f.Key, "%v must be at most %v", format(v), format(f.Max))
+		}
+	}
+	if len(errs) > 0 {
+		// The cross-field checks below assume valid individual settings.
+		return errs
+	}
+
+	if c.Tick > c.Timeframe {
+		errs.Errorf("tick", "tick %v is larger than the timeframe %v", c.Tick, c.Timeframe)
+	} else if n := c.NumTicks(); n > maxReasonableTicks {
+		errs.Warningf("tick", "%d ticks; the simulation will be slow", n)
+	}
+	if c.MinRefillAmount > c.MaxRefillAmount {
+		errs.Errorf(
+			"min_refill_amount", "min refill amount %v is larger than the max refill amount %v",
+			c.MinRefillAmount, c.MaxRefillAmount,
+		)
+	}
+	if c.TargetRefillPeriod < c.Tick {
+		errs.Warningf(
+			"target_refill_period_secs", "target refill period %v is shorter than the tick %v",
+			c.TargetRefillPeriod, c.Tick,
+		)
+	}
+	if c.BacklogTimeScale < c.Tick {
+		errs.Warningf(
+			"backlog_time_scale_secs", "backlog time scale %v is shorter than the tick %v",
+			c.BacklogTimeScale, c.Tick,
+		)
+	}
+	if c.PreRequestTime >= c.TargetRefillPeriod {
+		errs.Warningf(
+			"pre_request_time", "pre-request time %v is not shorter than the target refill period %v",
+			c.PreRequestTime, c.TargetRefillPeriod,
+		)
+	}
+	if c.GlobalLatency > 0 && c.GlobalLatency < c.Tick {
+		errs.Warningf(
+			"global_latency", "global latency %v is shorter than the tick %v (it is ignored)",
+			c.GlobalLatency, c.Tick,
+		)
+	}
+	if !validBudgetPolicy(c.BudgetPolicy) {
+		errs.Errorf(
+			"budget_policy", "unknown policy '%s' (must be one of: %s)",
+			c.BudgetPolicy, strings.Join(budgetPolicies, ", "),
+		)
+	}
+	if !validEstimateErrorDist(c.EstimateErrorDist) {
+		errs.Errorf(
+			"estimate_error_dist", "unknown distribution '%s' (must be one of: %s)",
+			c.EstimateErrorDist, strings.Join(estimateErrorDists, ", "),
+		)
+	}
+	if c.TargetRefillPeriod > c.Timeframe {
+		errs.Warningf(
+			"target_refill_period_secs", "target refill period %v is longer than the timeframe %v",
+			c.TargetRefillPeriod, c.Timeframe,
+		)
+	}
+	return errs
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"math"
+	"math/rand"
+)
+
+// This file models estimation errors. In practice, the RUs consumed by an
+// operation are estimated up front (this is what is requested and granted)
+// and corrected once the operation completes: the bucket that granted the
+// tokens is debited for the extra consumption, or refunded if the estimate was
+// too high. The corrections apply to both the distributed and the ideal token
+// bucket.
+
+// estimateErrorDists are the supported distributions of the estimation error.
+var estimateErrorDists = []string{"normal", "uniform", "lognormal"}
+
+func validEstimateErrorDist(dist string) bool {
+	for _, d := range estimateErrorDists {
+		if d == dist {
+			return true
+		}
+	}
+	return false
+}
+
+// estimationErrors returns true if the config enables estimation errors.
+func (c *Config) estimationErrors() bool {
+	return c.EstimateErrorMean != 0 || c.EstimateErrorStdDev != 0
+}
+
+// estimateErrors returns the relative estimation error of the work granted to
+// a node at each tick: the actual consumption is (1+e) times the granted
+// amount. The errors only depend on the config and the node, so all the
+// algorithms see the same errors.
+func estimateErrors(cfg *Config, nodeIdx int) Data {
+	res := ZeroData(cfg)
+	r := rand.New(rand.NewSource(int64(nodeIdx)*1000003 + 1))
+	mean, stddev := cfg.EstimateErrorMean, cfg.EstimateErrorStdDev
+	// Parameters of the lognormal distribution of 1+e.
+	sigma2 := math.Log(1 + stddev*stddev/((1+mean)*(1+mean)))
+	mu := math.Log(1+mean) - sigma2/2
+	for i := range res {
+		var e float64
+		switch cfg.EstimateErrorDist {
+		case "uniform":
+			e = mean + stddev*math.Sqrt(3)*(2*r.Float64()-1)
+		case "lognormal":
+			e = math.Exp(mu+math.Sqrt(sigma2)*r.NormFloat64()) - 1
+		default:
+			e = mean + stddev*r.NormFloat64()
+		}
+		// The actual consumption can't be negative.
+		res[i] = math.Max(e, -1)
+	}
+	return res
+}
+
+// corrections tracks the corrections for the work granted to a node.
+type corrections struct {
+	errors   Data
+	lagTicks int
+	// pending[t] is the correction (in RUs) due at tick t.
+	pending Data
+}
+
+// newCorrections returns nil if the config doesn't enable estimation errors.
+func newCorrections(cfg *Config, nodeIdx int) *corrections {
+	if !cfg.estimationErrors() {
+		return nil
+	}
+	return &corrections{
+		errors:   estimateErrors(cfg, nodeIdx),
+		lagTicks: cfg.TickForTime(cfg.CorrectionLag),
+		pending:  ZeroData(cfg),
+	}
+}
+
+// granted records the amount granted at the given tick. Corrections that would
+// be due after the timeframe are dropped.
+func (c *corrections) granted(now int, amount float64) {
+	if t := now + c.lagTicks; t < len(c.pending) {
+		c.pending[t] += amount * c.errors[now]
+	}
+}
+
+// due returns the correction at the given tick, which is applied at the end of
+// the tick (after granting): positive values are debited and negative values
+// are refunded.
+func (c *corrections) due(now int) float64 {
+	return c.pending[now]
+}
+
+// ActualConsumption returns the actual consumption rate of the work granted
+// to each node, taking into account the estimation errors (if enabled).
+func ActualConsumption(cfg *Config, granted PerNodeData) PerNodeData {
+	res := granted.Copy(cfg)
+	if !cfg.estimationErrors() {
+		return res
+	}
+	for i := range res {
+		errors := estimateErrors(cfg, i)
+		for t := range res[i] {
+			res[i][t] *= 1 + errors[t]
+		}
+	}
+	return res
+}
+
+// maxDebt returns the maximum amount by which the consumption exceeds what a
+// token bucket with the config allows.
+func maxDebt(cfg *Config, consumption Data) float64 {
+	tokens := cfg.InitialBurst
+	var res float64
+	for _, v := range consumption {
+		if tokens < cfg.MaxBurst {
+			tokens = math.Min(tokens+cfg.RatePerSec*cfg.Tick.Seconds(), cfg.MaxBurst)
+		}
+		tokens -= v * cfg.Tick.Seconds()
+		res = math.Max(res, -tokens)
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package lib
+
+import "strings"
+
+// This file implements the request unit (RU) cost model: instead of a rate of
+// RUs, a term can describe the rate of a kind of operation (see
+// FuncTerm.Operation), which is converted to RUs using the costs in the
+// Config.
+
+// operation is a kind of operation in the cost model.
+type operation struct {
+	key   string
+	label string
+	// unit is the unit of the rate.
+	unit string
+	// cost returns the cost of a unit of the operation, in RUs.
+	cost func(cfg *Config) float64
+}
+
+const bytesPerMiB = 1 << 20
+
+var operations = []operation{
+	{
+		key:   "read_batches",
+		label: "read batches",
+		unit:  "batches/s",
+		cost:  func(cfg *Config) float64 { return cfg.RUPerReadBatch },
+	},
+	{
+		key:   "read_bytes",
+		label: "read bytes",
+		unit:  "bytes/s",
+		cost:  func(cfg *Config) float64 { return cfg.RUPerReadMiB / bytesPerMiB },
+	},
+	{
+		key:   "write_batches",
+		label: "write batches",
+		unit:  "batches/s",
+		cost:  func(cfg *Config) float64 { return cfg.RUPerWriteBatch },
+	},
+	{
+		key:   "write_bytes",
+		label: "write bytes",
+		unit:  "bytes/s",
+		cost:  func(cfg *Config) float64 { return cfg.RUPerWriteMiB / bytesPerMiB },
+	},
+	{
+		key:   "sql_cpu_secs",
+		label: "SQL CPU",
+		unit:  "CPU seconds/s",
+		cost:  func(cfg *Config) float64 { return cfg.RUPerSQLCPUSec },
+	},
+}
+
+// costModelConfigKeys are the config settings used by the cost model.
+var costModelConfigKeys = []string{
+	"ru_per_read_batch", "ru_per_read_mib", "ru_per_write_batch", "ru_per_write_mib", "ru_per_sql_cpu_sec",
+}
+
+// findOperation returns the index of the operation with the given key in
+// operations, or -1.
+func findOperation(key string) int {
+	for i := range operations {
+		if operations[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// operationKeys returns the keys of all the operations, for error messages.
+func operationKeys() string {
+	keys := make([]string, len(operations))
+	for i := range operations {
+		keys[i] = operations[i].key
+	}
+	return strings.Join(keys, ", ")
+}
+
+// costBreakdown contains the aggregate requested rate (in RU/s) of the terms
+// without an operation and of each operation (indexed as in operations).
+type costBreakdown struct {
+	direct Data
+	ops    []Data
+	// used[i] is set if any term uses operations[i].
+	used []bool
+}
+
+// chart returns a chart with the contribution of each operation to the
+// requested rate.
+func (b *costBreakdown) chart(cfg *Config) Chart {
+	c := Chart{
+		Title: "Requested by operation (cost model)",
+		Units: []Unit{{Name: "RU/s"}},
+	}
+	if maxValue(b.direct) > 0 {
+		c.Series = append(c.Series, Series{Name: "RUs", Unit: "RU/s", Width: 1, Data: b.direct})
+	}
+	for i := range operations {
+		if b.used[i] {
+			c.Series = append(c.Series, Series{Name: operations[i].label, Unit: "RU/s", Width: 1, Data: b.ops[i]})
+		}
+	}
+	total := DataSum(cfg, append([]Data{b.direct}, b.ops...)...)
+	c.Series = append(c.Series, Series{Name: "total", Unit: "RU/s", Width: 2, Data: total})
+	return c
+}
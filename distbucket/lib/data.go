@@ -36,6 +36,27 @@ func DataSum(cfg *Config, d ...Data) Data {
 	return res
 }
 
+// Cumulative converts a rate to the total amount up to (and including) each
+// tick.
+func (d Data) Cumulative(cfg *Config) Data {
+	res := ZeroData(cfg)
+	var sum float64
+	for i := range res {
+		sum += d[i] * cfg.Tick.Seconds()
+		res[i] = sum
+	}
+	return res
+}
+
+// Diff returns d - other.
+func (d Data) Diff(cfg *Config, other Data) Data {
+	res := d.Copy(cfg)
+	for i := range res {
+		res[i] -= other[i]
+	}
+	return res
+}
+
 // Smooth applies exponential smoothing to the function;
 // alpha is in the range (0,1], with 1 being no smoothing.
 func (w Data) Smooth(cfg *Config, alpha float64) Data {
@@ -71,7 +92,10 @@ func (w Data) Smooth(cfg *Config, alpha float64) Data {
 // A FuncDesc is used to define a function using user-friendly building blocks.
 // The function is a sum of terms.
 type FuncDesc struct {
-	Terms []FuncTerm
+	// Templates lists templates (see Input.Templates) whose terms are added to
+	// the function.
+	Templates []string `yaml:",omitempty"`
+	Terms     []FuncTerm
 }
 
 // FuncTerm defines one term of a function.
@@ -79,31 +103,68 @@ type FuncTerm struct {
 	// Type is one of those in the switch in AddFunc.
 	Type string
 
-	Start    float64
-	Duration float64
+	Start    float64 `yaml:",omitempty"`
+	Duration float64 `yaml:",omitempty"`
 
-	Value float64 // used for "constant"
+	Value float64 `yaml:",omitempty"` // used for "constant"
 
-	Delta float64 // used for "ramp"
+	Delta float64 `yaml:",omitempty"` // used for "ramp"
 
-	Period    float64 // used for "sine"
-	Amplitude float64 // used for "sine" and "gaussian"
+	Period    float64 `yaml:",omitempty"` // used for "sine"
+	Phase     float64 `yaml:",omitempty"` // used for "sine" (in seconds)
+	Amplitude float64 `yaml:",omitempty"` // used for "sine" and "gaussian"
 
-	Smoothness int // used for "noise"
+	Smoothness int   `yaml:",omitempty"` // used for "noise"
+	Seed       int64 `yaml:",omitempty"` // used for "noise"
+
+	// Operation, if set, is the kind of operation whose rate is described by
+	// the term (e.g. "read_bytes", in bytes/s); the rate is converted to RU/s
+	// with the cost model in the Config. By default, the term is in RU/s.
+	Operation string `yaml:",omitempty"`
 }
 
-func (s Data) AddFuncTerm(cfg *Config, f FuncTerm) {
-	convTime := func(v float64) int {
-		return cfg.TickForTime(time.Duration(v * float64(time.Second)))
+// Validate checks the term parameters. The paths of the returned errors are
+// relative to the term (e.g. "start").
+func (f FuncTerm) Validate(cfg *Config) InputErrors {
+	var errs InputErrors
+	if d := time.Duration(f.Start * float64(time.Second)); d < 0 || d > cfg.Timeframe {
+		errs.Errorf("start", "time %v out of range [0, %v]", f.Start, cfg.Timeframe.Seconds())
 	}
-	convTimeCheck := func(v float64) int {
-		d := time.Duration(v * float64(time.Second))
-		if d < 0 || d > cfg.Timeframe {
-			throw(fmt.Sprintf("time %v out of range", v))
+	switch f.Type {
+	case "constant", "ramp":
+	case "sine":
+		if cfg.TickForTime(time.Duration(f.Period*float64(time.Second))) <= 0 {
+			errs.Errorf("period", "invalid sine period %v (must be at least one tick)", f.Period)
+		}
+	case "gaussian":
+		if f.Duration <= 0 {
+			errs.Errorf("duration", "invalid gaussian duration %v", f.Duration)
+		}
+	case "noise":
+		if f.Smoothness <= 0 {
+			errs.Errorf("smoothness", "invalid noise smoothness %v", f.Smoothness)
 		}
+	case "":
+		errs.Errorf("type", "func type not specified")
+	default:
+		errs.Errorf("type", "func type '%s' not supported", f.Type)
+	}
+	if f.Operation != "" && findOperation(f.Operation) < 0 {
+		errs.Errorf("operation", "unknown operation '%s' (must be one of: %s)", f.Operation, operationKeys())
+	}
+	return errs
+}
+
+// AddFuncTerm adds the given term to the function. Returns the problems found
+// by FuncTerm.Validate, if any.
+func (s Data) AddFuncTerm(cfg *Config, f FuncTerm) error {
+	if errs := f.Validate(cfg); errs.HasErrors() {
+		return errs
+	}
+	convTime := func(v float64) int {
 		return cfg.TickForTime(time.Duration(v * float64(time.Second)))
 	}
-	startTick := convTimeCheck(f.Start)
+	startTick := convTime(f.Start)
 	endTick := cfg.NumTicks()
 	if f.Duration != 0 {
 		if end := convTime(f.Start + f.Duration); end < endTick {
@@ -127,13 +188,12 @@ func (s Data) AddFuncTerm(cfg *Config, f FuncTerm) {
 		}
 
 	case "sine":
-		if f.Period <= 0 {
-			throw("invalid sine period")
-		}
 		period := cfg.TickForTime(time.Duration(f.Period * float64(time.Second)))
+		phase := f.Phase / cfg.Tick.Seconds()
 
 		for i := startTick; i < endTick; i++ {
-			s[i] += f.Amplitude * (0.5 + 0.5*math.Sin(-0.5*math.Pi+2*math.Pi*float64(i-startTick)/float64(period)))
+			x := (float64(i-startTick) + phase) / float64(period)
+			s[i] += f.Amplitude * (0.5 + 0.5*math.Sin(-0.5*math.Pi+2*math.Pi*x))
 		}
 
 	case "gaussian":
@@ -155,12 +215,13 @@ func (s Data) AddFuncTerm(cfg *Config, f FuncTerm) {
 		// We generate random gaussian noise for one tick in every f.Smoothness
 		// ticks and we use cosine interpolation in-between. See:
 		//   https://www.cs.umd.edu/class/spring2018/cmsc425/Lects/lect12-1d-perlin.pdf
-		if f.Smoothness == 0 {
-			throw("invalid noise smoothness")
-		}
 		// We choose the standard deviation so that Amplitude is width at 1% of maximum: 2*sqrt(2*ln(100)).
 		stddev := f.Amplitude / (2 * math.Sqrt(2*math.Log(100)))
-		r := rand.New(rand.NewSource(int64(f.Amplitude * float64(f.Smoothness))))
+		seed := int64(f.Amplitude * float64(f.Smoothness))
+		if f.Seed != 0 {
+			seed = seed*1000003 + f.Seed
+		}
+		r := rand.New(rand.NewSource(seed))
 		var last float64
 		next := r.NormFloat64() * stddev
 		for i := startTick; i < endTick; i++ {
@@ -175,18 +236,25 @@ func (s Data) AddFuncTerm(cfg *Config, f FuncTerm) {
 			gAlpha := (1 - math.Cos(math.Pi*alpha)) / 2
 			s[i] += (1-gAlpha)*last + gAlpha*next
 		}
-
-	default:
-		throw("func type '%s' not supported", f.Type)
 	}
+	return nil
 }
 
-func DataFromFuncDesc(cfg *Config, desc FuncDesc) Data {
+// DataFromFuncDesc generates the function described by desc. All problems with
+// the terms are returned as InputErrors, with paths relative to desc (e.g.
+// "terms[1].start"). Templates are not expanded (see Input.Requested).
+func DataFromFuncDesc(cfg *Config, desc FuncDesc) (Data, error) {
 	w := ZeroData(cfg)
-	for _, f := range desc.Terms {
-		w.AddFuncTerm(cfg, f)
+	var errs InputErrors
+	for i, f := range desc.Terms {
+		if err := w.AddFuncTerm(cfg, f); err != nil {
+			errs = append(errs, toInputErrors(err).withPrefix(fmt.Sprintf("terms[%d]", i))...)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
 	}
-	return w
+	return w, nil
 }
 
 type PerNodeData []Data
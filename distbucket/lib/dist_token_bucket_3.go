@@ -8,17 +8,30 @@ import (
 type globalBucket struct {
 	currTokens float64
 	sharesSum  float64
+
+	// budget is set if the config has a spending budget.
+	budget *budget
+
+	// server is set if the config enables the capacity model; the requests are
+	// then sent through the server instead of being processed right away.
+	server *globalServer
+
+	// events, if set, records all the requests.
+	events *EventLog
 }
 
 func (gb *globalBucket) init(cfg *Config) {
 	gb.currTokens = cfg.InitialBurst
+	gb.budget = newBudget(cfg)
+	gb.server = newGlobalServer(cfg)
 }
 
 func (gb *globalBucket) tick(cfg *Config, now int) {
+	gb.budget.tick(now)
 	// If we have more than MaxBurst, then the initial burst was larger and we
 	// are still using it.
 	if gb.currTokens < cfg.MaxBurst {
-		gb.currTokens += cfg.RatePerSec * cfg.Tick.Seconds()
+		gb.currTokens += gb.budget.refillRate(cfg) * cfg.Tick.Seconds()
 		if gb.currTokens > cfg.MaxBurst {
 			gb.currTokens = cfg.MaxBurst
 		}
@@ -35,9 +48,22 @@ func (gb *globalBucket) request(
 		throw("requested negative tokens")
 	}
 	gb.sharesSum = gb.sharesSum - prevShares + shares
+	// Guard against floating point drift when shares vary wildly in magnitude;
+	// the sum can never be smaller than our own shares.
+	if gb.sharesSum < shares {
+		gb.sharesSum = shares
+	}
+
+	maxTicks := cfg.TickForTime(cfg.TargetRefillPeriod)
+	// With a hard stop, we never grant more than the remaining budget.
+	tokens = gb.budget.limit(tokens)
+	if tokens == 0 {
+		return 0, now + maxTicks
+	}
 
 	if gb.currTokens >= tokens {
 		gb.currTokens -= tokens
+		gb.budget.charge(tokens)
 		return tokens, now
 	}
 
@@ -46,19 +72,20 @@ func (gb *globalBucket) request(
 		tokens -= gb.currTokens
 	}
 
-	availableRate := cfg.RatePerSec
+	rate := gb.budget.refillRate(cfg)
+	availableRate := rate
 	if gb.currTokens < 0 {
 		debt := -gb.currTokens
 		// We pre-distribute what we receive over the next TargetRefillPeriod; any
 		// debt over that is a systematic error we need to account for.
 
-		debt -= cfg.TargetRefillPeriod.Seconds() * cfg.RatePerSec
+		debt -= cfg.TargetRefillPeriod.Seconds() * rate
 		if debt > 0 {
 			// Say that we want to pay the debt over the next RefillPeriod (but use at
 			// most 90% of the rate for the debt).
 			debtRate := debt / cfg.TargetRefillPeriod.Seconds()
 			availableRate -= debtRate
-			availableRate = math.Max(availableRate, 0.01*cfg.RatePerSec)
+			availableRate = math.Max(availableRate, 0.01*rate)
 		}
 	}
 	// Give out a proportional share of the global rate (even if it is larger than
@@ -68,13 +95,14 @@ func (gb *globalBucket) request(
 
 	allowedRatePerTick := allowedRate * cfg.Tick.Seconds()
 
-	// Calculate how many ticks we need to accumulate the necessary amount.
-	ticks := int(tokens/allowedRatePerTick + 0.5)
+	// Calculate how many ticks we need to accumulate the necessary amount. This
+	// can be huge when the rate is tiny, so we compare as floats before
+	// converting to int.
+	ticks := tokens/allowedRatePerTick + 0.5
 	//fmt.Printf("allowedRate: %v  allowedRatePerTick: %v  ticks: %v\n", allowedRate, allowedRatePerTick, ticks)
-	maxTicks := cfg.TickForTime(cfg.TargetRefillPeriod)
-	if ticks <= maxTicks {
+	if ticks <= float64(maxTicks) {
 		grantedTokens += tokens
-		deadlineTick = now + ticks
+		deadlineTick = now + int(ticks)
 	} else {
 		// We don't want to plan ahead for more than the target period; give out
 		// fewer tokens.
@@ -83,10 +111,15 @@ func (gb *globalBucket) request(
 	}
 
 	gb.currTokens -= grantedTokens
+	gb.budget.charge(grantedTokens)
 	return grantedTokens, deadlineTick
 }
 
+// maxBacklogExp is the maximum exponent used when weighing backlog by age.
+const maxBacklogExp = 100
+
 type localBucket struct {
+	nodeIdx   int
 	requested Data
 	expTable  Data
 
@@ -104,19 +137,30 @@ type localBucket struct {
 
 	nextUpdateTick int
 
+	// corrections is set if the config enables estimation errors.
+	corrections *corrections
+
+	// inFlight is set while a request to the global bucket server is pending.
+	inFlight bool
+
 	r *rand.Rand
 }
 
 func (l *localBucket) init(cfg *Config, requested Data, nodeIdx int) {
+	l.nodeIdx = nodeIdx
 	l.requested = requested
 	l.outstanding = requested.Copy(cfg)
 	l.granted = ZeroData(cfg)
 	l.expTable = ZeroData(cfg)
 	for i := range l.expTable {
 		//l.expTable[i] = math.Exp(cfg.TimeForTick(i).Seconds() / 10)
-		l.expTable[i] = math.Exp(float64(cfg.TimeForTick(i)) / float64(cfg.BacklogTimeScale))
+		// The exponent is capped so that the weights (and the shares) stay finite
+		// when the backlog is much older than BacklogTimeScale.
+		exp := float64(cfg.TimeForTick(i)) / float64(cfg.BacklogTimeScale)
+		l.expTable[i] = math.Exp(math.Min(exp, maxBacklogExp))
 	}
 	l.r = rand.New(rand.NewSource(int64(nodeIdx)))
+	l.corrections = newCorrections(cfg, nodeIdx)
 }
 
 func (l *localBucket) distribute(now int, amount float64, deadlineTick int) {
@@ -139,19 +183,21 @@ func (l *localBucket) distribute(now int, amount float64, deadlineTick int) {
 	l.currRatePerTick = amount / float64(deadlineTick-now)
 }
 
-func (l *localBucket) maintain(cfg *Config, gb *globalBucket, now int) {
+// refillRequest returns the amount of tokens and the shares to request from
+// the global bucket, or ok=false if the bucket doesn't need a refill yet.
+func (l *localBucket) refillRequest(cfg *Config, now int) (amount, shares float64, ok bool) {
 	if l.currTokens > l.lastRefillAmount*cfg.RefillFraction {
-		return
+		return 0, 0, false
 	}
 	if float64(l.deadlineTick-now)*cfg.Tick.Seconds() > cfg.PreRequestTime.Seconds() {
-		return
+		return 0, 0, false
 	}
 
 	alpha := math.Pow(cfg.EWMAFactor, cfg.Tick.Seconds())
 	l.reqEWMA = l.reqEWMA*alpha + l.requested[now]*(1-alpha)
+	backlog, weightedBacklog := l.backlog(now)
 
 	// Calculate refill amount.
-	var amount float64
 	if l.lastRefillAmount == 0 {
 		// Initial request.
 		amount = cfg.InitialRefillAmount
@@ -159,9 +205,7 @@ func (l *localBucket) maintain(cfg *Config, gb *globalBucket, now int) {
 		amount = l.reqEWMA * float64(cfg.TargetRefillPeriod.Seconds()/cfg.Tick.Seconds())
 
 		// Add the queued work that has not been granted yet.
-		for i := l.outstandingTick; i <= now; i++ {
-			amount += l.outstanding[i]
-		}
+		amount += backlog
 
 		amount = math.Max(amount, cfg.MinRefillAmount)
 		amount = math.Min(amount, cfg.MaxRefillAmount)
@@ -169,40 +213,130 @@ func (l *localBucket) maintain(cfg *Config, gb *globalBucket, now int) {
 
 	// Calculate the amount of shares. We start with a small baseline so we never
 	// send a zero rate.
-	shares := 1e-10
+	shares = 1e-10
 	// Add the EWMA of requested RUs per tick as an estimate of the load.
 	shares += l.reqEWMA
 
 	// Now take into account the queued work that wasn't granted yet. The
 	// requests are weighed exponentially by age, so that nodes progress through
 	// their backlog at approximately the same rate.
-	var queued float64
-	for i := l.outstandingTick; i <= now; i++ {
-		queued += l.outstanding[i] * l.expTable[now-i] // */ math.Exp(cfg.TimeForTick(now-i).Seconds()/10)
-	}
-	shares += queued * math.Pow(10, cfg.BacklogFactorLog10)
+	shares += weightedBacklog * math.Pow(10, cfg.BacklogFactorLog10)
+	return amount, shares, true
+}
 
-	granted, deadlineTick := gb.request(cfg, now, l.lastShares, shares, amount)
+// refill distributes the tokens granted by the global bucket in response to a
+// request with the given shares.
+func (l *localBucket) refill(now int, shares, granted float64, deadlineTick int) {
 	l.lastShares = shares
-	// TODO(radu): simulate RTT.
 	l.distribute(now, granted, deadlineTick)
 }
 
+func (l *localBucket) maintain(cfg *Config, gb *globalBucket, now int) {
+	if l.inFlight {
+		return
+	}
+	amount, shares, ok := l.refillRequest(cfg, now)
+	if !ok {
+		return
+	}
+	if gb.server != nil {
+		gb.server.send(pendingRequest{
+			nodeIdx:    l.nodeIdx,
+			sentTick:   now,
+			readyTick:  now,
+			prevShares: l.lastShares,
+			shares:     shares,
+			amount:     amount,
+		})
+		l.inFlight = true
+		return
+	}
+	granted, deadlineTick := gb.processRequest(cfg, now, l.nodeIdx, l.lastShares, shares, amount)
+	l.refill(now, shares, granted, deadlineTick)
+}
+
+// receive applies a response from the global bucket server.
+func (l *localBucket) receive(now int, resp refillResponse) {
+	l.inFlight = false
+	if resp.failed {
+		// The node will send a new request.
+		return
+	}
+	// The deadline can pass while the response is delayed.
+	deadlineTick := resp.deadlineTick
+	if deadlineTick < now {
+		deadlineTick = now
+	}
+	l.refill(now, resp.shares, resp.granted, deadlineTick)
+}
+
+// processRequest is like request, and it also records the request (if
+// enabled).
+func (gb *globalBucket) processRequest(
+	cfg *Config, now int, nodeIdx int, prevShares float64, shares float64, amount float64,
+) (granted float64, deadlineTick int) {
+	tokensBefore := gb.currTokens
+	granted, deadlineTick = gb.request(cfg, now, prevShares, shares, amount)
+	if gb.events != nil {
+		*gb.events = append(*gb.events, RefillEvent{
+			Tick:               now,
+			Time:               cfg.TimeForTick(now).Seconds(),
+			Node:               nodeIdx + 1,
+			PrevShares:         prevShares,
+			Shares:             shares,
+			Requested:          amount,
+			Granted:            granted,
+			DeadlineTick:       deadlineTick,
+			GlobalTokensBefore: tokensBefore,
+			GlobalTokensAfter:  gb.currTokens,
+		})
+	}
+	return granted, deadlineTick
+}
+
+// backlog returns the work requested up to the given tick that has not been
+// granted yet, along with the same work weighted exponentially by age.
+func (l *localBucket) backlog(now int) (backlog, weighted float64) {
+	for i := l.outstandingTick; i <= now; i++ {
+		backlog += l.outstanding[i]
+		weighted += l.outstanding[i] * l.expTable[now-i] // */ math.Exp(cfg.TimeForTick(now-i).Seconds()/10)
+	}
+	return backlog, weighted
+}
+
 func (l *localBucket) request(cfg *Config, now int, amount float64) float64 {
 	if l.currTokens > amount {
 		l.currTokens -= amount
 		return amount
 	}
-	available := l.currTokens
-	l.currTokens = 0
+	// The tokens can be negative after a correction.
+	available := math.Max(l.currTokens, 0)
+	l.currTokens -= available
 	return available
 }
 
 func (l *localBucket) tick(cfg *Config, gb *globalBucket, now int) {
 	l.maintain(cfg, gb, now)
-	if l.deadlineTick >= now {
+	l.consume(cfg, now)
+}
+
+// consume adds the tokens from the last refill that are due at this tick,
+// grants as much of the outstanding requests as possible and applies the
+// corrections that are due.
+func (l *localBucket) consume(cfg *Config, now int) {
+	// The tokens are distributed over the ticks in [now, deadlineTick).
+	if l.deadlineTick > now {
 		l.currTokens += l.currRatePerTick
 	}
+	l.grant(cfg, now)
+	if l.corrections != nil {
+		l.corrections.granted(now, l.granted[now])
+		l.currTokens -= l.corrections.due(now)
+	}
+}
+
+// grant grants as much of the outstanding requests as possible.
+func (l *localBucket) grant(cfg *Config, now int) {
 	for l.outstandingTick <= now {
 		amount := l.outstanding[l.outstandingTick]
 		if amount == 0 {
@@ -219,44 +353,11 @@ func (l *localBucket) tick(cfg *Config, gb *globalBucket, now int) {
 }
 
 func DistTokenBucket3(cfg *Config, requested PerNodeData) (granted PerNodeData, globalTokens Data) {
-	globalTokens = ZeroData(cfg)
-	granted = MakePerNodeData(cfg, len(requested))
 	if len(requested) == 0 {
-		return granted, globalTokens
-	}
-
-	// Make copies of requested, since we are going to modify the data.
-	requested = requested.Copy(cfg)
-
-	tickDuration := cfg.Tick.Seconds()
-	// Convert from rate to absolute amount.
-	for i := range requested {
-		requested[i].Scale(tickDuration)
-	}
-
-	var global globalBucket
-	global.init(cfg)
-
-	local := make([]localBucket, len(requested))
-	for i := range local {
-		local[i].init(cfg, requested[i], i)
+		return MakePerNodeData(cfg, 0), ZeroData(cfg)
 	}
-
-	for now := range globalTokens {
-		global.tick(cfg, now)
-		globalTokens[now] = global.currTokens
-
-		for n := range local {
-			local[n].tick(cfg, &global, now)
-		}
-	}
-	for i := range granted {
-		granted[i] = local[i].granted
-	}
-
-	// Convert from absolute amount to rate.
-	for i := range granted {
-		granted[i].Scale(1.0 / tickDuration)
+	s := NewSimulation(cfg, requested)
+	for s.Step() {
 	}
-	return granted, globalTokens
+	return s.Results()
 }
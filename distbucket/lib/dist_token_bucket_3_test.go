@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDistTokenBucket3RefillDeadline checks that the local bucket doesn't hand
+// out more tokens than it got from the global bucket. The tokens from a refill
+// are distributed over the ticks in [now, deadlineTick); adding them at
+// deadlineTick too gives out an extra tick's worth with every refill that runs
+// to its deadline.
+func TestDistTokenBucket3RefillDeadline(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Timeframe = 5 * time.Minute
+	cfg.RatePerSec = 100
+	cfg.RefillFraction = 0.5
+	// The node requests slightly less than the refill rate, so the refills run
+	// to their deadline and the local bucket accumulates the extra tokens; then
+	// it requests a lot more, so that all the tokens are granted.
+	requested := MakePerNodeData(&cfg, 1)
+	for i := range requested[0] {
+		requested[0][i] = 99
+		if i > len(requested[0])/2 {
+			requested[0][i] = 1000
+		}
+	}
+	granted, tokens := DistTokenBucket3(&cfg, requested)
+	aggregate := granted.Aggregate(&cfg)
+	var total float64
+	for i := 0; i+1 < len(aggregate); i++ {
+		total += aggregate[i] * cfg.Tick.Seconds()
+		// The amount taken out of the global bucket is the initial burst plus
+		// the refills, minus whatever is left in the bucket.
+		limit := cfg.InitialBurst + cfg.RatePerSec*cfg.TimeForTick(i+2).Seconds() - tokens[i+1]
+		if total > limit+tolerance(limit) {
+			t.Fatalf("total granted %v exceeds %v at tick %d", total, limit, i)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// Downsampling methods.
+const (
+	// DownsampleLTTB uses the largest-triangle-three-buckets algorithm: each
+	// series keeps the point in each bucket which best preserves its shape.
+	DownsampleLTTB = "lttb"
+	// DownsampleMinMax keeps the minimum and the maximum in each bucket (in the
+	// order in which they occur).
+	DownsampleMinMax = "minmax"
+)
+
+// minDownsamplePoints is the smallest supported output resolution.
+const minDownsamplePoints = 4
+
+// maxOutputPoints is the number of points per series above which we suggest
+// downsampling.
+const maxOutputPoints = 100000
+
+// Downsample reduces the number of points of all the series to at most the
+// given number. The time axis is divided into the same buckets for all the
+// series, so the series remain aligned; the time of each point is the time of
+// its bucket, so points can shift in time by up to half a bucket.
+func (o *Output) Downsample(points int, method string) error {
+	if points < minDownsamplePoints {
+		return fmt.Errorf("invalid number of points %d (must be at least %d)", points, minDownsamplePoints)
+	}
+	n := len(o.TimeAxis)
+	if n <= points {
+		return nil
+	}
+	var downsample func(d []float64) []float64
+	switch method {
+	case DownsampleLTTB, "":
+		o.TimeAxis, downsample = lttb(o.TimeAxis, points)
+	case DownsampleMinMax:
+		o.TimeAxis, downsample = minMax(o.TimeAxis, points/2)
+	default:
+		return fmt.Errorf("unknown downsampling method '%s'", method)
+	}
+	for i := range o.Charts {
+		for j := range o.Charts[i].Series {
+			if s := &o.Charts[i].Series[j]; len(s.Data) == n {
+				s.Data = downsample(s.Data)
+			}
+		}
+	}
+	return nil
+}
+
+// lttb returns the downsampled time axis, along with a function that
+// downsamples a series using the largest-triangle-three-buckets algorithm.
+// The first and last points are kept as they are; the other points are
+// divided into points-2 buckets.
+func lttb(t []float64, points int) ([]float64, func(d []float64) []float64) {
+	n := len(t)
+	buckets := points - 2
+	every := float64(n-2) / float64(buckets)
+	// bucket returns the range of indexes for the i-th bucket.
+	bucket := func(i int) (lo, hi int) {
+		return int(float64(i)*every) + 1, int(float64(i+1)*every) + 1
+	}
+	newT := make([]float64, points)
+	newT[0], newT[points-1] = t[0], t[n-1]
+	for i := 0; i < buckets; i++ {
+		lo, hi := bucket(i)
+		newT[i+1] = 0.5 * (t[lo] + t[hi-1])
+	}
+
+	return newT, func(d []float64) []float64 {
+		res := make([]float64, points)
+		res[0], res[points-1] = d[0], d[n-1]
+		// a is the index of the point selected in the previous bucket.
+		a := 0
+		for i := 0; i < buckets; i++ {
+			lo, hi := bucket(i)
+			// The third point of the triangle is the average of the next bucket
+			// (or the last point).
+			nextLo, nextHi := n-1, n
+			if i+1 < buckets {
+				nextLo, nextHi = bucket(i + 1)
+			}
+			var avgT, avgD float64
+			for j := nextLo; j < nextHi; j++ {
+				avgT += t[j]
+				avgD += d[j]
+			}
+			avgT /= float64(nextHi - nextLo)
+			avgD /= float64(nextHi - nextLo)
+
+			best, bestArea := lo, -1.0
+			for j := lo; j < hi; j++ {
+				area := math.Abs((t[a]-avgT)*(d[j]-d[a]) - (t[a]-t[j])*(avgD-d[a]))
+				if area > bestArea {
+					best, bestArea = j, area
+				}
+			}
+			res[i+1] = d[best]
+			a = best
+		}
+		return res
+	}
+}
+
+// minMax returns the downsampled time axis, along with a function that
+// downsamples a series to the minimum and maximum in each of the buckets.
+func minMax(t []float64, buckets int) ([]float64, func(d []float64) []float64) {
+	n := len(t)
+	bucket := func(i int) (lo, hi int) {
+		return i * n / buckets, (i + 1) * n / buckets
+	}
+	newT := make([]float64, 2*buckets)
+	for i := 0; i < buckets; i++ {
+		lo, hi := bucket(i)
+		newT[2*i], newT[2*i+1] = t[lo], t[lo+(hi-lo)/2]
+	}
+
+	return newT, func(d []float64) []float64 {
+		res := make([]float64, 2*buckets)
+		for i := 0; i < buckets; i++ {
+			lo, hi := bucket(i)
+			minIdx, maxIdx := lo, lo
+			for j := lo; j < hi; j++ {
+				if d[j] < d[minIdx] {
+					minIdx = j
+				}
+				if d[j] > d[maxIdx] {
+					maxIdx = j
+				}
+			}
+			if minIdx > maxIdx {
+				minIdx, maxIdx = maxIdx, minIdx
+			}
+			res[2*i], res[2*i+1] = d[minIdx], d[maxIdx]
+		}
+		return res
+	}
+}
@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Severity indicates whether a problem with the input prevents the simulation
+// from running.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// InputError describes a problem with the input.
+type InputError struct {
+	// Path identifies the offending setting, e.g. "nodes[2].terms[1].start".
+	// Indexes are 0-based. Empty if the problem is not tied to a setting.
+	Path string
+	// Line and Column are the 1-based position of the setting in the input
+	// YAML; they are zero if unknown.
+	Line   int
+	Column int
+
+	Severity Severity
+	Message  string
+}
+
+func (e InputError) Error() string {
+	var b strings.Builder
+	if e.Line != 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Path != "" {
+		fmt.Fprintf(&b, "%s: ", e.Path)
+	}
+	if e.Severity == SeverityWarning {
+		b.WriteString("warning: ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// InputErrors accumulates problems with the input.
+type InputErrors []InputError
+
+func (e InputErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *InputErrors) addf(path string, severity Severity, format string, args ...interface{}) {
+	*e = append(*e, InputError{
+		Path:     path,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Errorf adds an error for the setting at the given path.
+func (e *InputErrors) Errorf(path string, format string, args ...interface{}) {
+	e.addf(path, SeverityError, format, args...)
+}
+
+// Warningf adds a warning for the setting at the given path.
+func (e *InputErrors) Warningf(path string, format string, args ...interface{}) {
+	e.addf(path, SeverityWarning, format, args...)
+}
+
+// HasErrors returns true if there are any problems of SeverityError.
+func (e InputErrors) HasErrors() bool {
+	return len(e.Filter(SeverityError)) > 0
+}
+
+// Filter returns the problems with the given severity.
+func (e InputErrors) Filter(severity Severity) InputErrors {
+	var res InputErrors
+	for i := range e {
+		if e[i].Severity == severity {
+			res = append(res, e[i])
+		}
+	}
+	return res
+}
+
+// withPrefix returns a copy of the errors with the paths prefixed by the given
+// path.
+func (e InputErrors) withPrefix(prefix string) InputErrors {
+	res := make(InputErrors, len(e))
+	for i := range e {
+		res[i] = e[i]
+		if res[i].Path == "" {
+			res[i].Path = prefix
+		} else if strings.HasPrefix(res[i].Path, "[") {
+			res[i].Path = prefix + res[i].Path
+		} else {
+			res[i].Path = prefix + "." + res[i].Path
+		}
+	}
+	return res
+}
+
+// locate fills in the line and column of each error, using the paths and the
+// positions of the settings in the input (see inputPositions). If a setting is
+// not present in the input (e.g. because it was defaulted), we use the
+// position of the closest enclosing setting.
+func (e InputErrors) locate(positions map[string]Position) {
+	for i := range e {
+		if e[i].Line != 0 {
+			continue
+		}
+		for p := e[i].Path; p != ""; p = parentPath(p) {
+			if pos, ok := positions[p]; ok {
+				e[i].Line, e[i].Column = pos.Line, pos.Column
+				break
+			}
+		}
+	}
+}
+
+// parentPath strips the last component of a path: "nodes[1].terms" becomes
+// "nodes[1]" which becomes "nodes".
+func parentPath(path string) string {
+	if i := strings.LastIndexAny(path, ".["); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// toInputErrors converts an error to InputErrors, if it isn't one already.
+func toInputErrors(err error) InputErrors {
+	if errs, ok := err.(InputErrors); ok {
+		return errs
+	}
+	return InputErrors{{Severity: SeverityError, Message: err.Error()}}
+}
+
+var yamlLineRegexp = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+
+// yamlErrors converts an error from the YAML parser into InputErrors,
+// extracting the line numbers from the messages.
+func yamlErrors(inputYAML string, err error) InputErrors {
+	msgs := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	}
+	lines := strings.Split(inputYAML, "\n")
+	var res InputErrors
+	for _, msg := range msgs {
+		e := InputError{
+			Severity: SeverityError,
+			Message:  strings.TrimPrefix(msg, "yaml: "),
+		}
+		if m := yamlLineRegexp.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Message = m[2]
+			if e.Line > 0 && e.Line <= len(lines) {
+				l := lines[e.Line-1]
+				e.Column = len(l) - len(strings.TrimLeft(l, " ")) + 1
+			}
+		}
+		res = append(res, e)
+	}
+	return res
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RefillEvent records a request from a local bucket to the global bucket.
+type RefillEvent struct {
+	Tick int
+	// Time is the time of the request, in seconds.
+	Time float64
+	// Node is the 1-based node index (as in the chart series names).
+	Node int
+
+	PrevShares float64
+	Shares     float64
+
+	Requested    float64
+	Granted      float64
+	DeadlineTick int
+
+	GlobalTokensBefore float64
+	GlobalTokensAfter  float64
+}
+
+// EventLog is a list of events, in the order in which they happened.
+type EventLog []RefillEvent
+
+var eventLogColumns = []string{
+	"tick", "time", "node", "prev_shares", "shares", "requested", "granted",
+	"deadline_tick", "global_tokens_before", "global_tokens_after",
+}
+
+func (e *RefillEvent) values() []string {
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return []string{
+		strconv.Itoa(e.Tick), f(e.Time), strconv.Itoa(e.Node), f(e.PrevShares), f(e.Shares),
+		f(e.Requested), f(e.Granted), strconv.Itoa(e.DeadlineTick),
+		f(e.GlobalTokensBefore), f(e.GlobalTokensAfter),
+	}
+}
+
+// WriteCSV writes the events in CSV format, with a header.
+func (l EventLog) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(eventLogColumns); err != nil {
+		return err
+	}
+	for i := range l {
+		if err := cw.Write(l[i].values()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSONLines writes the events as JSON objects, one per line.
+func (l EventLog) WriteJSONLines(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for i := range l {
+		if err := enc.Encode(&l[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes the events in the given format ("csv" or "jsonl").
+func (l EventLog) Write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return l.WriteCSV(w)
+	case "jsonl":
+		return l.WriteJSONLines(w)
+	default:
+		return fmt.Errorf("unknown event log format '%s'", format)
+	}
+}
+
+// Markers returns chart markers for the events.
+func (l EventLog) Markers() []Marker {
+	res := make([]Marker, len(l))
+	for i, e := range l {
+		res[i] = Marker{
+			Time:   e.Time,
+			Series: fmt.Sprintf("n%d", e.Node),
+			Label: fmt.Sprintf(
+				"n%d requested %.1f RU, granted %.1f RU over %d ticks (global tokens %.1f -> %.1f)",
+				e.Node, e.Requested, e.Granted, e.DeadlineTick-e.Tick, e.GlobalTokensBefore, e.GlobalTokensAfter,
+			),
+		}
+	}
+	return res
+}
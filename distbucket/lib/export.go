@@ -0,0 +1,269 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains exporters for the charts in an Output, for use outside
+// of the UI (e.g. in notebooks or in Grafana).
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteChartCSV writes a chart in CSV format: one row per time, with a column
+// for each series.
+func (o *Output) WriteChartCSV(w io.Writer, chart *Chart) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time"}
+	for _, s := range chart.Series {
+		header = append(header, fmt.Sprintf("%s (%s)", s.Name, s.Unit))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	row := make([]string, len(header))
+	for i, t := range o.TimeAxis {
+		row[0] = formatFloat(t)
+		for j, s := range chart.Series {
+			row[j+1] = ""
+			if i < len(s.Data) {
+				row[j+1] = formatFloat(s.Data[i])
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLongCSV writes all the charts in "long" CSV format, with one row per
+// value: chart, series, unit, time, value.
+func (o *Output) WriteLongCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"chart", "series", "unit", "time", "value"}); err != nil {
+		return err
+	}
+	for _, c := range o.Charts {
+		for _, s := range c.Series {
+			for i, v := range s.Data {
+				if i >= len(o.TimeAxis) {
+					break
+				}
+				row := []string{c.Title, s.Name, s.Unit, formatFloat(o.TimeAxis[i]), formatFloat(v)}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// columnarMagic identifies the binary columnar format (see WriteColumnar).
+const columnarMagic = "DBCOL"
+
+// columnarVersion is the version of the binary columnar format.
+const columnarVersion = 1
+
+// WriteColumnar writes the time axis and the charts in a compact binary
+// columnar format. All integers are unsigned varints, strings are a length
+// followed by the UTF-8 bytes, and columns are a length followed by the
+// little-endian float64 values:
+//
+//	"DBCOL" version(=1)
+//	time-axis-column
+//	num-charts
+//	for each chart:
+//	  title num-series
+//	  for each series: name unit column
+func (o *Output) WriteColumnar(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var buf [binary.MaxVarintLen64]byte
+	writeUint := func(v int) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(v))])
+	}
+	writeString := func(s string) {
+		writeUint(len(s))
+		bw.WriteString(s)
+	}
+	writeColumn := func(d []float64) {
+		writeUint(len(d))
+		for _, v := range d {
+			binary.LittleEndian.PutUint64(buf[:8], math.Float64bits(v))
+			bw.Write(buf[:8])
+		}
+	}
+	bw.WriteString(columnarMagic)
+	writeUint(columnarVersion)
+	writeColumn(o.TimeAxis)
+	writeUint(len(o.Charts))
+	for _, c := range o.Charts {
+		writeString(c.Title)
+		writeUint(len(c.Series))
+		for _, s := range c.Series {
+			writeString(s.Name)
+			writeString(s.Unit)
+			writeColumn(s.Data)
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadColumnar reads the time axis and the charts written by WriteColumnar.
+// The other fields of the Output (like the chart units) are not set.
+func ReadColumnar(r io.Reader) (Output, error) {
+	br := bufio.NewReader(r)
+	var err error
+	readUint := func() int {
+		var v uint64
+		if err == nil {
+			v, err = binary.ReadUvarint(br)
+			if err == nil && v > math.MaxInt32 {
+				err = errors.New("invalid length")
+			}
+		}
+		return int(v)
+	}
+	readBytes := func(n int) []byte {
+		if err != nil {
+			return nil
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(br, b)
+		return b
+	}
+	readString := func() string {
+		return string(readBytes(readUint()))
+	}
+	readColumn := func() []float64 {
+		n := readUint()
+		b := readBytes(8 * n)
+		if err != nil {
+			return nil
+		}
+		d := make([]float64, n)
+		for i := range d {
+			d[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+		}
+		return d
+	}
+
+	if magic := readBytes(len(columnarMagic)); err == nil && string(magic) != columnarMagic {
+		return Output{}, errors.New("not a columnar output file")
+	}
+	if v := readUint(); err == nil && v != columnarVersion {
+		return Output{}, fmt.Errorf("unsupported columnar format version %d", v)
+	}
+	var out Output
+	out.TimeAxis = readColumn()
+	numCharts := readUint()
+	for i := 0; i < numCharts && err == nil; i++ {
+		c := Chart{Title: readString()}
+		numSeries := readUint()
+		for j := 0; j < numSeries && err == nil; j++ {
+			c.Series = append(c.Series, Series{Name: readString(), Unit: readString(), Data: readColumn()})
+		}
+		out.Charts = append(out.Charts, c)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Output{}, err
+	}
+	return out, nil
+}
+
+var metricNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// metricName converts a chart title and a unit to a metric name, e.g.
+// "distbucket_granted_distributed_token_bucket_ru_per_second".
+func metricName(title, unit string) (name, unitSuffix string) {
+	unitSuffix = strings.ReplaceAll(strings.ToLower(unit), "/s", "_per_second")
+	unitSuffix = strings.Trim(metricNameRegexp.ReplaceAllString(unitSuffix, "_"), "_")
+	name = "distbucket_" + strings.Trim(metricNameRegexp.ReplaceAllString(strings.ToLower(title), "_"), "_")
+	if unitSuffix != "" {
+		name += "_" + unitSuffix
+	}
+	return name, unitSuffix
+}
+
+// escapeString escapes a label value or the HELP text.
+func escapeString(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// WriteOpenMetrics writes the charts as gauges in the OpenMetrics text format.
+// There is a metric for each chart and unit, with a "series" label; the
+// timestamps are synthetic, with the simulation starting at the given time.
+//
+// The output has a sample for each tick, so it can't be scraped; it is meant
+// for backfilling, e.g. with "promtool tsdb create-blocks-from openmetrics".
+func (o *Output) WriteOpenMetrics(w io.Writer, start time.Time) error {
+	bw := bufio.NewWriter(w)
+	timestamp := func(t float64) string {
+		// OpenMetrics timestamps are in seconds.
+		ts := start.Add(time.Duration(t * float64(time.Second)))
+		return strconv.FormatFloat(float64(ts.UnixNano())/1e9, 'f', -1, 64)
+	}
+	value := func(v float64) string {
+		switch {
+		case math.IsInf(v, 1):
+			return "+Inf"
+		case math.IsInf(v, -1):
+			return "-Inf"
+		case math.IsNaN(v):
+			return "NaN"
+		}
+		return formatFloat(v)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range o.Charts {
+		var units []string
+		for _, s := range c.Series {
+			if !seen[c.Title+"\x00"+s.Unit] {
+				seen[c.Title+"\x00"+s.Unit] = true
+				units = append(units, s.Unit)
+			}
+		}
+		for _, unit := range units {
+			name, unitSuffix := metricName(c.Title, unit)
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeString(fmt.Sprintf("%s (%s)", c.Title, unit)))
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+			if unitSuffix != "" {
+				fmt.Fprintf(bw, "# UNIT %s %s\n", name, unitSuffix)
+			}
+			for _, s := range c.Series {
+				if s.Unit != unit {
+					continue
+				}
+				for i, v := range s.Data {
+					if i >= len(o.TimeAxis) {
+						break
+					}
+					fmt.Fprintf(
+						bw, "%s{series=\"%s\"} %s %s\n",
+						name, escapeString(s.Name), value(v), timestamp(o.TimeAxis[i]),
+					)
+				}
+			}
+		}
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
@@ -0,0 +1,664 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// This file implements the reverse of DataFromFuncDesc: it approximates a
+// recorded rate series with a compact set of terms, so that workloads derived
+// from real data can be shared (and edited) without sharing the data.
+//
+// The fit is greedy: starting with a constant baseline, at each step we find
+// the best candidate of each kind (a ramp at the largest level shift, a sine
+// at the strongest period, a gaussian at the largest burst) and keep the one
+// which explains most of the remaining variance. The coefficients of all the
+// terms are refitted (with least squares) after each step. Whatever remains
+// is modeled by a noise term.
+
+// FitOptions contains the settings for FitFuncDesc.
+type FitOptions struct {
+	// MaxRamps, MaxSines and MaxBursts limit the number of terms of each kind.
+	MaxRamps  int
+	MaxSines  int
+	MaxBursts int
+	// A term is only added if it reduces the residual sum of squares by at
+	// least MinGain times the sum of squares of the series (around the mean).
+	// Once all the terms are added, those that reduce it by less than
+	// Significance times the variance of the residual times its correlation
+	// length (in ticks) are removed; this avoids fitting terms to noise.
+	MinGain      float64
+	Significance float64
+	// Noise enables the noise term, which models the residual.
+	Noise bool
+}
+
+var DefaultFitOptions = FitOptions{
+	MaxRamps:     3,
+	MaxSines:     3,
+	MaxBursts:    5,
+	MinGain:      0.002,
+	Significance: 50,
+	Noise:        true,
+}
+
+// FitResult is the result of FitFuncDesc.
+type FitResult struct {
+	Desc FuncDesc
+	// Fitted is the function defined by Desc, without the noise term.
+	Fitted Data
+	// RMSE is the root mean square error of Fitted, and StdDev is the
+	// standard deviation of the series (for reference).
+	RMSE   float64
+	StdDev float64
+}
+
+// fitSignificantDigits is the precision of the fitted parameters; more
+// digits make the terms harder to read without making them more useful.
+const fitSignificantDigits = 4
+
+// FitFuncDesc approximates the given rate series (one value per tick) with a
+// set of terms: a constant baseline, ramps, sines, gaussian bursts and noise.
+// It returns an error if the series has the wrong length or values that are
+// not finite.
+func FitFuncDesc(cfg *Config, d Data, opts FitOptions) (FitResult, error) {
+	if n := cfg.NumTicks(); len(d) != n {
+		return FitResult{}, fmt.Errorf("the series has %d values, expected %d (one per tick)", len(d), n)
+	}
+	if len(d) == 0 {
+		return FitResult{Desc: FuncDesc{Terms: []FuncTerm{Constant(0)}}, Fitted: d}, nil
+	}
+	for i, v := range d {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return FitResult{}, fmt.Errorf("invalid value %v at %s", v, cfg.TimeForTick(i))
+		}
+	}
+	f := fitter{cfg: cfg, data: d}
+	mean := sum(d) / float64(len(d))
+	for _, v := range d {
+		f.totalVar += (v - mean) * (v - mean)
+	}
+	if math.IsInf(f.totalVar, 0) {
+		return FitResult{}, fmt.Errorf("the values are too large to fit")
+	}
+	if f.totalVar == 0 {
+		// A constant series is fitted exactly by its value.
+		desc := FuncDesc{Terms: []FuncTerm{Constant(mean)}}
+		return FitResult{Desc: desc, Fitted: d.Copy(cfg)}, nil
+	}
+	f.add(f.component(Constant(1)))
+
+	counts := make(map[string]int)
+	limits := map[string]int{"ramp": opts.MaxRamps, "sine": opts.MaxSines, "gaussian": opts.MaxBursts}
+	for f.totalVar > 0 {
+		var best *fitComponent
+		bestRSS := f.rss - opts.MinGain*f.totalVar
+		for _, kind := range []string{"ramp", "sine", "gaussian"} {
+			if counts[kind] >= limits[kind] {
+				continue
+			}
+			candidates := f.candidates(kind)
+			if f.err != nil {
+				return FitResult{}, f.err
+			}
+			for _, c := range candidates {
+				if _, rss := f.solve(append(f.comps, c)); rss < bestRSS {
+					c := c
+					best, bestRSS = &c, rss
+				}
+			}
+		}
+		if best == nil {
+			break
+		}
+		f.add(*best)
+		counts[best.terms[0].Type]++
+	}
+	f.prune(opts.Significance)
+
+	res := FitResult{
+		Desc:   FuncDesc{Terms: f.terms()},
+		StdDev: math.Sqrt(f.totalVar / float64(len(d))),
+	}
+	// The parameters were rounded, so we regenerate the function.
+	var err error
+	res.Fitted, err = DataFromFuncDesc(cfg, res.Desc)
+	if err != nil {
+		return FitResult{}, fmt.Errorf("invalid fitted terms: %v", err)
+	}
+	residual := d.Diff(cfg, res.Fitted)
+	for _, v := range residual {
+		res.RMSE += v * v
+	}
+	res.RMSE = math.Sqrt(res.RMSE / float64(len(d)))
+	if opts.Noise {
+		if noise, ok := fitNoise(residual); ok {
+			res.Desc.Terms = append(res.Desc.Terms, noise)
+		}
+	}
+	return res, nil
+}
+
+// fitComponent is a term of the fitted function, with unit coefficients. The
+// coefficients of the columns are fitted with least squares. Sines have two
+// columns (with phases a quarter period apart), which allows fitting the
+// phase as well.
+type fitComponent struct {
+	terms   []FuncTerm
+	columns []Data
+}
+
+type fitter struct {
+	cfg      *Config
+	data     Data
+	totalVar float64
+
+	comps []fitComponent
+	coefs []float64
+	rss   float64
+	// err is set if a component can't be generated (see component).
+	err error
+}
+
+// component generates the columns for the given (unit) terms. If a term is
+// invalid, f.err is set and the component has no columns.
+func (f *fitter) component(terms ...FuncTerm) fitComponent {
+	c := fitComponent{terms: terms}
+	for _, t := range terms {
+		col := ZeroData(f.cfg)
+		if err := col.AddFuncTerm(f.cfg, t); err != nil {
+			if f.err == nil {
+				f.err = fmt.Errorf("invalid fit term %+v: %v", t, err)
+			}
+			return fitComponent{terms: terms}
+		}
+		c.columns = append(c.columns, col)
+	}
+	return c
+}
+
+func (f *fitter) add(c fitComponent) {
+	f.comps = append(f.comps, c)
+	f.coefs, f.rss = f.solve(f.comps)
+}
+
+// prune removes the terms (except the baseline) which are not significant,
+// starting with the least significant one.
+func (f *fitter) prune(significance float64) {
+	for len(f.comps) > 1 {
+		_, variance, lag := correlationLag(f.residual())
+		threshold := significance * variance * float64(lag)
+		worst, worstRSS := -1, 0.0
+		for i := 1; i < len(f.comps); i++ {
+			comps := append(append([]fitComponent(nil), f.comps[:i]...), f.comps[i+1:]...)
+			if _, rss := f.solve(comps); rss-f.rss < threshold && (worst < 0 || rss < worstRSS) {
+				worst, worstRSS = i, rss
+			}
+		}
+		if worst < 0 {
+			return
+		}
+		f.comps = append(f.comps[:worst], f.comps[worst+1:]...)
+		f.coefs, f.rss = f.solve(f.comps)
+	}
+}
+
+// residual returns the data minus the current fit.
+func (f *fitter) residual() Data {
+	res := f.data.Copy(f.cfg)
+	k := 0
+	for _, c := range f.comps {
+		for _, col := range c.columns {
+			for i := range res {
+				res[i] -= f.coefs[k] * col[i]
+			}
+			k++
+		}
+	}
+	return res
+}
+
+// solve finds the least squares coefficients of all the columns of the given
+// components (using the normal equations) and returns them along with the
+// residual sum of squares.
+func (f *fitter) solve(comps []fitComponent) (coefs []float64, rss float64) {
+	var cols []Data
+	for _, c := range comps {
+		cols = append(cols, c.columns...)
+	}
+	m := len(cols)
+	// a is the augmented matrix of the normal equations.
+	a := make([][]float64, m)
+	for j := range a {
+		a[j] = make([]float64, m+1)
+		for k := 0; k <= j; k++ {
+			a[j][k] = dot(cols[j], cols[k])
+			a[k][j] = a[j][k]
+		}
+		a[j][m] = dot(cols[j], f.data)
+	}
+	// Gaussian elimination with partial pivoting. Columns that are (nearly)
+	// linear combinations of previous columns get a zero coefficient.
+	coefs = make([]float64, m)
+	pivots := make([]int, m)
+	for j := range pivots {
+		pivots[j] = -1
+	}
+	row := 0
+	for col := 0; col < m && row < m; col++ {
+		p := row
+		for r := row + 1; r < m; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[p][col]) {
+				p = r
+			}
+		}
+		if math.Abs(a[p][col]) <= 1e-9*math.Sqrt(dot(cols[col], cols[col]))*math.Sqrt(float64(len(f.data))) {
+			continue
+		}
+		a[row], a[p] = a[p], a[row]
+		for r := row + 1; r < m; r++ {
+			factor := a[r][col] / a[row][col]
+			for k := col; k <= m; k++ {
+				a[r][k] -= factor * a[row][k]
+			}
+		}
+		pivots[col] = row
+		row++
+	}
+	for col := m - 1; col >= 0; col-- {
+		r := pivots[col]
+		if r < 0 {
+			continue
+		}
+		v := a[r][m]
+		for k := col + 1; k < m; k++ {
+			v -= a[r][k] * coefs[k]
+		}
+		coefs[col] = v / a[r][col]
+	}
+
+	for i, v := range f.data {
+		for j := range cols {
+			v -= coefs[j] * cols[j][i]
+		}
+		rss += v * v
+	}
+	return coefs, rss
+}
+
+// candidates returns the candidate components of the given kind, based on the
+// current residual.
+func (f *fitter) candidates(kind string) []fitComponent {
+	residual := f.residual()
+	switch kind {
+	case "ramp":
+		return f.rampCandidates(residual)
+	case "sine":
+		if period, ok := f.findPeriod(residual); ok {
+			return []fitComponent{f.sineComponent(period)}
+		}
+	case "gaussian":
+		if c, ok := f.findBurst(residual); ok {
+			return []fitComponent{c}
+		}
+	}
+	return nil
+}
+
+// rampCandidates returns ramps of various widths at the largest level shift
+// in the residual, and a ramp over the entire timeframe (a trend).
+func (f *fitter) rampCandidates(residual Data) []fitComponent {
+	n := len(residual)
+	if n < 2 {
+		return nil
+	}
+	res := []fitComponent{f.component(Ramp(1))}
+	// The level shift is the split point which maximizes the difference
+	// between the means on the two sides (weighted by the sizes).
+	var total float64
+	for _, v := range residual {
+		total += v
+	}
+	best, bestScore := 0, 0.0
+	var prefix float64
+	for k := 1; k < n; k++ {
+		prefix += residual[k-1]
+		diff := prefix - float64(k)/float64(n)*total
+		if score := diff * diff / (float64(k) * float64(n-k)); score > bestScore {
+			best, bestScore = k, score
+		}
+	}
+	if best == 0 {
+		return res
+	}
+	// The widths grow geometrically, up to a fifth of the timeframe.
+	prevWidth := 0
+	for w := 1.0; w <= float64(n)/5; w *= 1.25 {
+		width := int(w)
+		if width == prevWidth {
+			continue
+		}
+		prevWidth = width
+		start := best - width/2
+		if start < 0 {
+			start = 0
+		}
+		if start+width > n {
+			width = n - start
+		}
+		res = append(res, f.component(
+			Ramp(1).From(f.cfg.TimeForTick(start)).For(f.cfg.TimeForTick(width)),
+		))
+	}
+	return res
+}
+
+// maxPeriodogramPoints limits the size of the series used to find the
+// strongest period; longer series are averaged down.
+const maxPeriodogramPoints = 4096
+
+// findPeriod returns the period (in ticks) of the strongest periodic component
+// of the residual, which is one that repeats at least twice in the timeframe.
+func (f *fitter) findPeriod(residual Data) (int, bool) {
+	n := len(residual)
+	// Average down to at most maxPeriodogramPoints.
+	step := (n + maxPeriodogramPoints - 1) / maxPeriodogramPoints
+	var series []float64
+	for i := 0; i+step <= n; i += step {
+		series = append(series, sum(residual[i:i+step])/float64(step))
+	}
+	m := len(series)
+	// Find the number of cycles (in the m points) which fits best.
+	bestCycles, bestPower := 0, 0.0
+	for k := 2; 2*k <= m; k++ {
+		if period := periodForCycles(n, k); period < 2 || f.hasSine(period) {
+			continue
+		}
+		if p := power(series, float64(m)/float64(k)); p > bestPower {
+			bestCycles, bestPower = k, p
+		}
+	}
+	if bestCycles == 0 {
+		return 0, false
+	}
+	// Refine the period using the entire residual; the frequency resolution of
+	// the periodogram is one cycle per timeframe.
+	lo := periodForCycles(n, bestCycles+1)
+	hi := periodForCycles(n, bestCycles-1)
+	best, bestPower := 0, 0.0
+	refine := func(from, to, step int) {
+		for p := from; p <= to; p += step {
+			if p < 2 || f.hasSine(p) {
+				continue
+			}
+			if pw := power(residual, float64(p)); pw > bestPower {
+				best, bestPower = p, pw
+			}
+		}
+	}
+	coarse := (hi - lo + 99) / 100
+	if coarse < 1 {
+		coarse = 1
+	}
+	refine(lo, hi, coarse)
+	if coarse > 1 && best != 0 {
+		refine(best-coarse+1, best+coarse-1, 1)
+	}
+	return best, best != 0
+}
+
+// periodForCycles returns the period of k cycles in n ticks.
+func periodForCycles(n, k int) int {
+	return int(math.Round(float64(n) / float64(k)))
+}
+
+func (f *fitter) hasSine(period int) bool {
+	for _, c := range f.comps {
+		if t := c.terms[0]; t.Type == "sine" && f.cfg.TickForTime(time.Duration(t.Period*float64(time.Second))) == period {
+			return true
+		}
+	}
+	return false
+}
+
+// sineComponent returns a sine with the given period (in ticks).
+func (f *fitter) sineComponent(period int) fitComponent {
+	periodSecs := fitSeconds(f.cfg, period)
+	quarter := f.cfg.TimeForTick(1).Seconds() * float64(period) / 4
+	return f.component(
+		FuncTerm{Type: "sine", Amplitude: 1, Period: periodSecs},
+		FuncTerm{Type: "sine", Amplitude: 1, Period: periodSecs, Phase: quarter},
+	)
+}
+
+// findBurst returns a gaussian at the largest peak (or dip) of the smoothed
+// residual, with the same width at half maximum.
+func (f *fitter) findBurst(residual Data) (fitComponent, bool) {
+	n := len(residual)
+	window := n / 500
+	if window < 1 {
+		window = 1
+	}
+	smoothed := make([]float64, n)
+	var s float64
+	for i := range residual {
+		s += residual[i]
+		if i >= window {
+			s -= residual[i-window]
+		}
+		// Center the window on i.
+		if j := i - window/2; j >= 0 {
+			smoothed[j] = s / float64(window)
+		}
+	}
+	peak := 0
+	for i := range smoothed {
+		if math.Abs(smoothed[i]) > math.Abs(smoothed[peak]) {
+			peak = i
+		}
+	}
+	half := smoothed[peak] / 2
+	if half == 0 {
+		return fitComponent{}, false
+	}
+	l, r := peak, peak
+	for l > 0 && smoothed[l-1]/half > 1 {
+		l--
+	}
+	for r < n-1 && smoothed[r+1]/half > 1 {
+		r++
+	}
+	tick := f.cfg.Tick.Seconds()
+	center := (float64(l+r)/2 + 0.5) * tick
+	// The duration of a gaussian is its width at 1% of the maximum.
+	duration := float64(r-l+1) * tick * math.Sqrt(math.Log(100)/math.Log(2))
+	if duration > float64(n)*tick/2 {
+		// This is not a burst.
+		return fitComponent{}, false
+	}
+	if duration > 2*center {
+		// The start must be in the timeframe.
+		duration = 2 * center
+	}
+	return f.component(FuncTerm{
+		Type:      "gaussian",
+		Amplitude: 1,
+		Start:     center - duration/2,
+		Duration:  duration,
+	}), true
+}
+
+// terms converts the components to terms, with rounded parameters.
+func (f *fitter) terms() []FuncTerm {
+	var res []FuncTerm
+	// Sines oscillate between 0 and the amplitude, so they add to the baseline.
+	var baseline float64
+	k := 0
+	for _, c := range f.comps {
+		t := c.terms[0]
+		coef := f.coefs[k]
+		k += len(c.columns)
+		switch t.Type {
+		case "constant":
+			baseline += coef
+			continue
+		case "ramp":
+			t.Delta = roundSignificant(coef)
+		case "gaussian":
+			t.Amplitude = roundSignificant(coef)
+			t.Start = roundSignificant(t.Start)
+			t.Duration = roundSignificant(t.Duration)
+		case "sine":
+			// The two columns are 0.5 - 0.5*cos(x) and 0.5 + 0.5*sin(x), so the
+			// sum is 0.5*(a+b) + r*cos(x - phi); a sine with amplitude 2r and a
+			// phase of pi-phi is 0.5*2r + r*cos(x - phi).
+			a, b := coef, f.coefs[k-1]
+			baseline += 0.5 * (a + b)
+			r := 0.5 * math.Hypot(a, b)
+			phi := math.Atan2(0.5*b, -0.5*a)
+			baseline -= r
+			period := t.Period
+			phase := math.Mod((math.Pi-phi)/(2*math.Pi)*period+period, period)
+			t.Amplitude = roundSignificant(2 * r)
+			t.Phase = roundSignificant(phase)
+		}
+		res = append(res, t)
+	}
+	constant := Constant(roundSignificant(baseline))
+	return append([]FuncTerm{constant}, res...)
+}
+
+// fitNoise returns a noise term with the same standard deviation and
+// smoothness as the residual.
+func fitNoise(residual Data) (FuncTerm, bool) {
+	_, variance, lag := correlationLag(residual)
+	if variance == 0 {
+		return FuncTerm{}, false
+	}
+	// The smoothness is the distance between the random points of the noise,
+	// which are interpolated.
+	smoothness := int(math.Round(noiseSmoothnessPerLag * float64(lag)))
+	if lag == 1 {
+		smoothness = 1
+	}
+	// The interpolated values have a smaller variance than the random points.
+	var factor float64
+	for i := 0; i < smoothness; i++ {
+		g := (1 - math.Cos(math.Pi*float64(i)/float64(smoothness))) / 2
+		factor += (1-g)*(1-g) + g*g
+	}
+	factor /= float64(smoothness)
+	amplitude := math.Sqrt(variance/factor) * 2 * math.Sqrt(2*math.Log(100))
+	return Noise(roundSignificant(amplitude), smoothness), true
+}
+
+// correlationLag returns the mean and variance of the series, and the lag (in
+// ticks) at which its autocorrelation drops below one half.
+func correlationLag(d Data) (mean, variance float64, lag int) {
+	mean = sum(d) / float64(len(d))
+	for _, v := range d {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(d))
+	for lag = 1; lag < len(d)/4; lag++ {
+		var c float64
+		for i := lag; i < len(d); i++ {
+			c += (d[i] - mean) * (d[i-lag] - mean)
+		}
+		if c/float64(len(d)-lag) < 0.5*variance {
+			break
+		}
+	}
+	return mean, variance, lag
+}
+
+// noiseSmoothnessPerLag is the ratio between the smoothness of a noise term and
+// the lag at which its autocorrelation is one half.
+const noiseSmoothnessPerLag = 1.5
+
+// fitSeconds returns the time in seconds for the given number of ticks, such
+// that it converts back to the same number of ticks.
+func fitSeconds(cfg *Config, ticks int) float64 {
+	s := cfg.TimeForTick(ticks).Seconds()
+	for cfg.TickForTime(time.Duration(s*float64(time.Second))) < ticks {
+		s = math.Nextafter(s, math.Inf(1))
+	}
+	return s
+}
+
+func roundSignificant(v float64) float64 {
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', fitSignificantDigits, 64), 64)
+	return r
+}
+
+// power returns the reduction in the sum of squares of the series when fitting
+// a sine with the given period (in points) and any phase, along with a
+// constant.
+func power(series []float64, period float64) float64 {
+	n := float64(len(series))
+	var sc, ss, scc, sss, scs, sv, svc, svs float64
+	for i, v := range series {
+		x := 2 * math.Pi * float64(i) / period
+		c, s := math.Cos(x), math.Sin(x)
+		sc += c
+		ss += s
+		scc += c * c
+		sss += s * s
+		scs += c * s
+		sv += v
+		svc += v * c
+		svs += v * s
+	}
+	// Center the columns (which accounts for the constant) and solve the 2x2
+	// normal equations.
+	a, b, d := scc-sc*sc/n, scs-sc*ss/n, sss-ss*ss/n
+	y1, y2 := svc-sv*sc/n, svs-sv*ss/n
+	det := a*d - b*b
+	if det <= 1e-12*(a*d) {
+		return 0
+	}
+	return (d*y1*y1 - 2*b*y1*y2 + a*y2*y2) / det
+}
+
+func dot(a, b []float64) float64 {
+	var res float64
+	for i := range a {
+		res += a[i] * b[i]
+	}
+	return res
+}
+
+func sum(d []float64) float64 {
+	var res float64
+	for _, v := range d {
+		res += v
+	}
+	return res
+}
+
+// ResampleData converts a series of (time, value) samples to a rate series
+// with one value per tick, using linear interpolation. The times are in
+// seconds (from the start of the timeframe) and must be increasing. Ticks
+// before the first sample or after the last one get the nearest value.
+func ResampleData(cfg *Config, times, values []float64) Data {
+	res := ZeroData(cfg)
+	if len(times) == 0 {
+		return res
+	}
+	j := 0
+	for i := range res {
+		t := cfg.TimeForTick(i).Seconds()
+		for j+1 < len(times) && times[j+1] <= t {
+			j++
+		}
+		if j+1 == len(times) || t <= times[j] {
+			res[i] = values[j]
+			continue
+		}
+		alpha := (t - times[j]) / (times[j+1] - times[j])
+		res[i] = (1-alpha)*values[j] + alpha*values[j+1]
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFitFuncDesc fits a function generated from known terms (without noise)
+// and checks that the terms come back.
+func TestFitFuncDesc(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Timeframe = 10 * time.Minute
+	cfg.Tick = time.Second
+	d, err := DataFromFuncDesc(&cfg, Node(
+		Constant(100),
+		Ramp(50).From(2*time.Minute).For(time.Minute),
+		Sine(40, time.Minute),
+		Gaussian(200, 20*time.Second).From(6*time.Minute),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultFitOptions
+	opts.Noise = false
+	res, err := FitFuncDesc(&cfg, d, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RMSE > 0.05*res.StdDev {
+		t.Errorf("RMSE %.4g too large (standard deviation %.4g)", res.RMSE, res.StdDev)
+	}
+
+	expectNear := func(what string, actual, expected, tolerance float64) {
+		t.Helper()
+		if math.Abs(actual-expected) > tolerance {
+			t.Errorf("%s: expected %g, got %g (terms: %+v)", what, expected, actual, res.Desc.Terms)
+		}
+	}
+	terms := res.Desc.Terms
+	if terms[0].Type != "constant" {
+		t.Fatalf("expected a constant baseline, got %+v", terms[0])
+	}
+	expectNear("baseline", terms[0].Value, 100, 2)
+	var sines int
+	var rampDelta, burstAmplitude, burstStart float64
+	for _, term := range terms[1:] {
+		switch term.Type {
+		case "sine":
+			sines++
+			expectNear("sine period", term.Period, 60, 0)
+			expectNear("sine amplitude", term.Amplitude, 40, 2)
+		case "ramp":
+			// The ramp can be fitted with more than one term.
+			rampDelta += term.Delta
+		case "gaussian":
+			if term.Amplitude > burstAmplitude {
+				burstAmplitude, burstStart = term.Amplitude, term.Start
+			}
+		default:
+			t.Errorf("unexpected term %+v", term)
+		}
+	}
+	if sines != 1 {
+		t.Errorf("expected one sine, got %d (terms: %+v)", sines, terms)
+	}
+	expectNear("ramp delta", rampDelta, 50, 5)
+	expectNear("burst start", burstStart, 360, 5)
+	expectNear("burst amplitude", burstAmplitude, 200, 20)
+}
+
+func TestFitFuncDescErrors(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Timeframe = 10 * time.Second
+	cfg.Tick = time.Second
+	series := func(values ...float64) Data {
+		d := ZeroData(&cfg)
+		copy(d, values)
+		return d
+	}
+	testCases := []struct {
+		data Data
+		// err is the expected error; if empty, the fit must be a constant.
+		err string
+	}{
+		{data: Data{1, 2, 3}, err: "the series has 3 values, expected 10 (one per tick)"},
+		{data: series(1, math.NaN()), err: "invalid value NaN at 1s"},
+		{data: series(1, 2, math.Inf(-1)), err: "invalid value -Inf at 2s"},
+		{data: series(math.MaxFloat64, -math.MaxFloat64), err: "the values are too large to fit"},
+		{data: series(5, 5, 5, 5, 5, 5, 5, 5, 5, 5)},
+	}
+	for _, tc := range testCases {
+		res, err := FitFuncDesc(&cfg, tc.data, DefaultFitOptions)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%v: expected error '%s', got %v", tc.data, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.data, err)
+		} else if len(res.Desc.Terms) != 1 || res.Desc.Terms[0] != Constant(tc.data[0]) {
+			t.Errorf("%v: expected a constant, got %+v", tc.data, res.Desc.Terms)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Format is an input format.
+type Format string
+
+const (
+	// FormatAuto detects the format from the input (see DetectFormat).
+	FormatAuto Format = ""
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{FormatYAML, FormatJSON, FormatTOML}
+
+var tomlStartRegexp = regexp.MustCompile(`^(\[|[A-Za-z0-9_."'-]+\s*=)`)
+
+// DetectFormat guesses the format of an input: JSON if it starts with '{',
+// TOML if it starts with a table header or a "key = value" line, and YAML
+// otherwise.
+func DetectFormat(input string) Format {
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			return FormatJSON
+		case tomlStartRegexp.MatchString(line):
+			return FormatTOML
+		}
+		break
+	}
+	return FormatYAML
+}
+
+// resolve returns the format, detecting it if it is FormatAuto.
+func (f Format) resolve(input string) Format {
+	if f == FormatAuto {
+		return DetectFormat(input)
+	}
+	return f
+}
+
+// ParseFormat returns the format with the given name; the empty string
+// corresponds to FormatAuto.
+func ParseFormat(name string) (Format, error) {
+	f := Format(strings.ToLower(name))
+	if f == FormatAuto {
+		return f, nil
+	}
+	for _, v := range Formats {
+		if f == v {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format '%s' (must be one of: yaml, json, toml)", name)
+}
+
+// parseInputFormat is like parseInput, for any format. JSON and TOML inputs
+// are converted to YAML and go through the same parsing (so they are subject to
+// the same checks and defaults); the paths of the errors refer to the input but
+// the positions are not set (see locate).
+func parseInputFormat(input string, format Format) (Input, InputErrors) {
+	var tree yaml.MapSlice
+	var errs InputErrors
+	switch format.resolve(input) {
+	case FormatYAML:
+		return parseInput(input)
+	case FormatJSON:
+		tree, _, errs = parseJSONTree(input)
+	case FormatTOML:
+		tree, _, errs = parseTOMLTree(input)
+	default:
+		errs.Errorf("", "unknown format '%s'", format)
+	}
+	if errs.HasErrors() {
+		return Input{}, errs
+	}
+	converted, err := yaml.Marshal(tree)
+	if err != nil {
+		errs.Errorf("", "%v", err)
+		return Input{}, errs
+	}
+	in, errs := parseInput(string(converted))
+	// Convert the positions in the converted YAML to paths.
+	paths := make(map[int]string)
+	for path, pos := range yamlPositions(string(converted)) {
+		if p, ok := paths[pos.Line]; !ok || len(path) > len(p) {
+			paths[pos.Line] = path
+		}
+	}
+	for i := range errs {
+		if errs[i].Line != 0 {
+			if errs[i].Path == "" {
+				errs[i].Path = paths[errs[i].Line]
+			}
+			errs[i].Line, errs[i].Column = 0, 0
+		}
+	}
+	return in, errs
+}
+
+// inputPositions maps the paths of the settings in an input to their
+// positions (see yamlPositions).
+func inputPositions(input string, format Format) map[string]Position {
+	var positions map[string]Position
+	switch format.resolve(input) {
+	case FormatJSON:
+		_, positions, _ = parseJSONTree(input)
+	case FormatTOML:
+		_, positions, _ = parseTOMLTree(input)
+	default:
+		positions = yamlPositions(input)
+	}
+	return positions
+}
+
+// Marshal returns the input in the given format, suitable for ParseInput. The
+// output is canonical: the same input always results in the same text.
+func (in Input) Marshal(format Format) (string, error) {
+	text, err := in.YAML()
+	if err != nil || format == FormatYAML {
+		return text, err
+	}
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(text), &tree); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	switch format {
+	case FormatJSON:
+		err = writeJSON(&b, tree, "")
+		b.WriteString("\n")
+	case FormatTOML:
+		err = writeTOML(&b, nil, tree)
+	default:
+		err = fmt.Errorf("unknown format '%s'", format)
+	}
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// offsetPosition converts a byte offset in the text to a position.
+func offsetPosition(text string, offset int) Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	before := text[:offset]
+	line := strings.Count(before, "\n") + 1
+	return Position{Line: line, Column: offset - strings.LastIndexByte(before, '\n')}
+}
+
+// parseJSONTree parses a JSON object into the same form as YAML decoding into
+// a yaml.MapSlice, along with the positions of all the values.
+func parseJSONTree(text string) (yaml.MapSlice, map[string]Position, InputErrors) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	positions := make(map[string]Position)
+	// valuePos returns the position of the next token.
+	valuePos := func() Position {
+		offset := int(dec.InputOffset())
+		for offset < len(text) && strings.IndexByte(" \t\r\n,:", text[offset]) >= 0 {
+			offset++
+		}
+		return offsetPosition(text, offset)
+	}
+
+	var parseValue func(path string) (interface{}, error)
+	parseValue = func(path string) (interface{}, error) {
+		if path != "" {
+			positions[path] = valuePos()
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			if tok == '[' {
+				list := []interface{}{}
+				for i := 0; dec.More(); i++ {
+					v, err := parseValue(fmt.Sprintf("%s[%d]", path, i))
+					if err != nil {
+						return nil, err
+					}
+					list = append(list, v)
+				}
+				_, err := dec.Token()
+				return list, err
+			}
+			m := yaml.MapSlice{}
+			for dec.More() {
+				keyPos := valuePos()
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				keyPath := key.(string)
+				if path != "" {
+					keyPath = path + "." + keyPath
+				}
+				v, err := parseValue(keyPath)
+				if err != nil {
+					return nil, err
+				}
+				// Point to the key rather than the value.
+				positions[keyPath] = keyPos
+				m = append(m, yaml.MapItem{Key: key, Value: v})
+			}
+			_, err := dec.Token()
+			return m, err
+
+		case json.Number:
+			if i, err := tok.Int64(); err == nil {
+				return i, nil
+			}
+			f, err := tok.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s", tok)
+			}
+			return f, nil
+
+		default:
+			// string, bool or nil.
+			return tok, nil
+		}
+	}
+
+	var errs InputErrors
+	errorAt := func(offset int, err error) {
+		pos := offsetPosition(text, offset)
+		errs = append(errs, InputError{
+			Line:     pos.Line,
+			Column:   pos.Column,
+			Severity: SeverityError,
+			Message:  err.Error(),
+		})
+	}
+	root, err := parseValue("")
+	if err == nil {
+		if _, extraErr := dec.Token(); extraErr != io.EOF {
+			err = fmt.Errorf("unexpected data after the top-level object")
+		}
+	}
+	if err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			errorAt(int(syntaxErr.Offset), err)
+		} else {
+			errorAt(int(dec.InputOffset()), err)
+		}
+		return nil, positions, errs
+	}
+	m, ok := root.(yaml.MapSlice)
+	if !ok {
+		errs.Errorf("", "the input must be a JSON object")
+		return nil, positions, errs
+	}
+	return m, positions, nil
+}
+
+// writeJSON writes a value decoded from YAML (see Input.Marshal) as indented
+// JSON, preserving the order of the keys.
+func writeJSON(b *strings.Builder, v interface{}, indent string) error {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		if len(v) == 0 {
+			b.WriteString("{}")
+			return nil
+		}
+		b.WriteString("{\n")
+		for i, item := range v {
+			key, _ := json.Marshal(fmt.Sprint(item.Key))
+			fmt.Fprintf(b, "%s  %s: ", indent, key)
+			if err := writeJSON(b, item.Value, indent+"  "); err != nil {
+				return err
+			}
+			if i+1 < len(v) {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "}")
+
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString("[]")
+			return nil
+		}
+		b.WriteString("[\n")
+		for i := range v {
+			b.WriteString(indent + "  ")
+			if err := writeJSON(b, v[i], indent+"  "); err != nil {
+				return err
+			}
+			if i+1 < len(v) {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "]")
+
+	default:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		b.WriteString(strings.TrimSuffix(buf.String(), "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,690 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+// WorkloadGen generates random (but reproducible) inputs, used to fuzz the
+// token bucket implementations. The generated inputs are always valid.
+type WorkloadGen struct {
+	r *rand.Rand
+
+	// MaxNodes is the maximum number of nodes in a generated input (not
+	// counting the instances of groups).
+	MaxNodes int
+	// MaxTerms is the maximum number of terms for each node.
+	MaxTerms int
+	// MaxTicks is the maximum number of ticks in the simulation.
+	MaxTicks int
+	// MaxGroupCount is the maximum number of instances in a group.
+	MaxGroupCount int
+}
+
+func NewWorkloadGen(seed int64) *WorkloadGen {
+	return &WorkloadGen{
+		r:             rand.New(rand.NewSource(seed)),
+		MaxNodes:      10,
+		MaxTerms:      5,
+		MaxTicks:      5000,
+		MaxGroupCount: 20,
+	}
+}
+
+// Input generates a random input. Templates, groups and variants are only
+// used by some of the inputs.
+func (g *WorkloadGen) Input() Input {
+	in := Input{
+		Config: g.Config(),
+	}
+	var templates []string
+	if g.r.Intn(3) == 0 {
+		in.Templates = make(map[string][]FuncTerm)
+		for i := 0; i < 1+g.r.Intn(3); i++ {
+			name := fmt.Sprintf("t%d", i+1)
+			in.Templates[name] = g.FuncDesc(&in.Config, nil /* templates */).Terms
+			templates = append(templates, name)
+		}
+	}
+	in.Nodes = make([]FuncDesc, 1+g.r.Intn(g.MaxNodes))
+	for i := range in.Nodes {
+		in.Nodes[i] = g.FuncDesc(&in.Config, templates)
+	}
+	if g.r.Intn(3) == 0 {
+		in.Groups = make([]NodeGroup, 1+g.r.Intn(2))
+		for i := range in.Groups {
+			in.Groups[i] = g.NodeGroup(&in.Config, templates)
+		}
+	}
+	if g.r.Intn(4) == 0 {
+		in.Variants = make([]Variant, 2+g.r.Intn(2))
+		for i := range in.Variants {
+			in.Variants[i] = g.Variant()
+		}
+	}
+	return in
+}
+
+// Config generates a random configuration. Most values are in the usual
+// ranges, but every now and then we choose an extreme value. The budget, the
+// estimation errors, the global bucket capacity model and the cost model are
+// only enabled for some of the configurations.
+func (g *WorkloadGen) Config() Config {
+	cfg := DefaultConfig
+
+	cfg.Tick = []time.Duration{
+		time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second,
+	}[g.r.Intn(4)]
+	cfg.Timeframe = cfg.Tick * time.Duration(1+g.r.Intn(g.MaxTicks))
+
+	cfg.RatePerSec = g.value(1, 10000, 0, 1e-9, 1e9)
+	cfg.InitialBurst = g.value(0, 100000, 0, 1e9)
+	cfg.MaxBurst = g.value(0, 100000, 0, 1e-9, 1e9)
+
+	cfg.TargetRefillPeriod = g.duration(time.Second, 100*time.Second, cfg.Tick, cfg.Timeframe)
+	cfg.InitialRefillAmount = g.value(10, 10000, 1e-9, 1e12)
+	cfg.MinRefillAmount = g.value(10, 1000, 0, 1e-9, 1e12)
+	cfg.MaxRefillAmount = g.value(100, 100000, 1e-9, 1e12)
+	if cfg.MinRefillAmount > cfg.MaxRefillAmount {
+		cfg.MinRefillAmount, cfg.MaxRefillAmount = cfg.MaxRefillAmount, cfg.MinRefillAmount
+	}
+	cfg.RefillFraction = g.value(0.01, 1, 0, 1)
+	cfg.PreRequestTime = g.duration(0, 10*time.Second, 0, cfg.Timeframe)
+
+	cfg.EWMAFactor = g.value(0.01, 1, 0, 1)
+	cfg.BacklogTimeScale = g.duration(time.Second, 100*time.Second, cfg.Tick, cfg.Timeframe)
+	cfg.BacklogFactorLog10 = math.Round(g.uniform(-10, 10))
+
+	if g.r.Intn(4) == 0 {
+		cfg.Budget = g.value(1, 1000000, 1e-9)
+		cfg.BudgetPeriod = g.duration(0, cfg.Timeframe, cfg.Tick)
+		cfg.BudgetPolicy = budgetPolicies[g.r.Intn(len(budgetPolicies))]
+		cfg.BudgetReducedRate = g.value(1, 10000, 0, 1e9)
+	}
+	if g.r.Intn(4) == 0 {
+		cfg.EstimateErrorMean = g.value(-0.5, 1, 0, -0.999, 10)
+		cfg.EstimateErrorStdDev = g.value(0.01, 1, 0, 10)
+		cfg.EstimateErrorDist = estimateErrorDists[g.r.Intn(len(estimateErrorDists))]
+		cfg.CorrectionLag = g.duration(0, 10*time.Second, cfg.Timeframe)
+	}
+	if g.r.Intn(4) == 0 {
+		cfg.GlobalServiceRate = g.value(0.1, 100, 0, 1e9)
+		cfg.GlobalLatency = g.duration(0, time.Second, cfg.Tick, cfg.Timeframe)
+		cfg.GlobalConflictProb = g.value(0, 0.5, 0, 1)
+		cfg.GlobalRetryBackoff = g.duration(0, time.Second, cfg.Timeframe)
+		cfg.GlobalMaxRetries = g.r.Intn(10)
+	}
+	if g.r.Intn(4) == 0 {
+		cfg.RUPerReadBatch = g.value(0.01, 10, 0)
+		cfg.RUPerReadMiB = g.value(1, 1000, 0)
+		cfg.RUPerWriteBatch = g.value(0.01, 10, 0)
+		cfg.RUPerWriteMiB = g.value(1, 10000, 0)
+		cfg.RUPerSQLCPUSec = g.value(1, 10000, 0)
+	}
+
+	return cfg
+}
+
+// FuncDesc generates a random function, which uses some of the given
+// templates.
+func (g *WorkloadGen) FuncDesc(cfg *Config, templates []string) FuncDesc {
+	var desc FuncDesc
+	for _, name := range templates {
+		if g.r.Intn(2) == 0 {
+			desc.Templates = append(desc.Templates, name)
+		}
+	}
+	desc.Terms = make([]FuncTerm, 1+g.r.Intn(g.MaxTerms))
+	for i := range desc.Terms {
+		desc.Terms[i] = g.FuncTerm(cfg)
+	}
+	return desc
+}
+
+// NodeGroup generates a random group, which uses some of the given templates.
+func (g *WorkloadGen) NodeGroup(cfg *Config, templates []string) NodeGroup {
+	timeframe := cfg.Timeframe.Seconds()
+	desc := g.FuncDesc(cfg, templates)
+	return NodeGroup{
+		Count:           1 + g.r.Intn(g.MaxGroupCount),
+		Templates:       desc.Templates,
+		Terms:           desc.Terms,
+		AmplitudeJitter: g.value(0, 1, 0, 1),
+		PhaseJitter:     g.value(0, timeframe, 0),
+		Stagger:         g.value(0, timeframe/10, 0, timeframe),
+		Seed:            g.r.Int63(),
+	}
+}
+
+// variantSettings are the config settings overridden by generated variants,
+// along with functions that generate a value for each.
+var variantSettings = []struct {
+	key   string
+	value func(g *WorkloadGen) interface{}
+}{
+	{"rate_per_sec", func(g *WorkloadGen) interface{} { return g.value(1, 10000, 0) }},
+	{"max_burst", func(g *WorkloadGen) interface{} { return g.value(0, 100000, 0) }},
+	{"ewma_factor", func(g *WorkloadGen) interface{} { return g.value(0.01, 1, 0, 1) }},
+	{"refill_fraction", func(g *WorkloadGen) interface{} { return g.value(0.01, 1, 0, 1) }},
+	{"backlog_factor_log_10", func(g *WorkloadGen) interface{} { return math.Round(g.uniform(-10, 10)) }},
+	{"budget_policy", func(g *WorkloadGen) interface{} { return budgetPolicies[g.r.Intn(len(budgetPolicies))] }},
+	{"global_conflict_prob", func(g *WorkloadGen) interface{} { return g.value(0, 0.5, 0, 1) }},
+}
+
+// Variant generates a random variant, which overrides some of the config
+// settings. The name is left empty (so the default name is used).
+func (g *WorkloadGen) Variant() Variant {
+	var v Variant
+	v.Algorithm = []string{"", "distributed", "ideal"}[g.r.Intn(3)]
+	for _, s := range variantSettings {
+		if g.r.Intn(3) == 0 {
+			if v.Config == nil {
+				v.Config = make(map[string]interface{})
+			}
+			v.Config[s.key] = s.value(g)
+		}
+	}
+	return v
+}
+
+// FuncTerm generates a random function term.
+func (g *WorkloadGen) FuncTerm(cfg *Config) FuncTerm {
+	timeframe := cfg.Timeframe.Seconds()
+	var t FuncTerm
+	if g.r.Intn(2) == 0 {
+		t.Start = g.uniform(0, timeframe)
+	}
+	if g.r.Intn(2) == 0 {
+		t.Duration = g.uniform(0, timeframe)
+	}
+	switch g.r.Intn(5) {
+	case 0:
+		t.Type = "constant"
+		t.Value = g.uniform(-100, 1000)
+	case 1:
+		t.Type = "ramp"
+		t.Delta = g.uniform(-1000, 1000)
+	case 2:
+		t.Type = "sine"
+		t.Period = g.value(cfg.Tick.Seconds(), timeframe, timeframe*10)
+		t.Amplitude = g.value(1, 1000, 0)
+	case 3:
+		t.Type = "gaussian"
+		t.Amplitude = g.value(1, 1000, 0)
+		if t.Duration == 0 {
+			t.Duration = g.uniform(0, timeframe)
+		}
+	default:
+		t.Type = "noise"
+		t.Amplitude = g.value(1, 1000, 0)
+		t.Smoothness = 1 + g.r.Intn(100)
+	}
+	if g.r.Intn(5) == 0 {
+		t.Operation = operations[g.r.Intn(len(operations))].key
+	}
+	return t
+}
+
+func (g *WorkloadGen) uniform(min, max float64) float64 {
+	return min + g.r.Float64()*(max-min)
+}
+
+// value returns a random value in the [min, max) range, with a log-uniform
+// distribution. With a small probability, returns one of the given edge
+// values instead.
+func (g *WorkloadGen) value(min, max float64, edges ...float64) float64 {
+	if len(edges) > 0 && g.r.Intn(10) == 0 {
+		return edges[g.r.Intn(len(edges))]
+	}
+	if min <= 0 {
+		return g.uniform(min, max)
+	}
+	return math.Exp(g.uniform(math.Log(min), math.Log(max)))
+}
+
+func (g *WorkloadGen) duration(min, max time.Duration, edges ...time.Duration) time.Duration {
+	if len(edges) > 0 && g.r.Intn(10) == 0 {
+		return edges[g.r.Intn(len(edges))]
+	}
+	return min + time.Duration(g.r.Int63n(int64(max-min)+1))
+}
+
+// CheckInput runs both token bucket implementations on the given input and
+// verifies that they don't panic, that all outputs are finite, and that the
+// granted amounts are within conservation bounds. It also runs the input as a
+// whole (see Run), which checks the variants the same way. Problems with the
+// input are returned as InputErrors.
+func CheckInput(in Input) (err error) {
+	defer func() {
+		if obj := recover(); obj != nil {
+			err = fmt.Errorf("panic: %v", obj)
+		}
+	}()
+	if errs := in.Validate(); errs.HasErrors() {
+		return errs.Filter(SeverityError)
+	}
+	cfg := &in.Config
+	requested, err := in.Requested()
+	if err != nil {
+		return err
+	}
+	if err := checkFinite("requested", requested...); err != nil {
+		return err
+	}
+
+	granted, tokens := TokenBucket(cfg, requested)
+	if err := checkIdeal("ideal", cfg, requested, granted, tokens); err != nil {
+		return err
+	}
+	granted, tokens = DistTokenBucket3(cfg, requested)
+	if err := checkDistributed("distributed", cfg, requested, granted, tokens); err != nil {
+		return err
+	}
+
+	res, err := Run(in)
+	if err != nil {
+		return fmt.Errorf("run: %v", err)
+	}
+	for i := range res.Runs {
+		r := &res.Runs[i]
+		check := checkDistributed
+		if r.Algorithm == "ideal" {
+			check = checkIdeal
+		}
+		if err := check(r.Name, &r.Config, requested, r.Granted, r.Tokens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIdeal verifies the results of the ideal token bucket.
+func checkIdeal(what string, cfg *Config, requested, granted PerNodeData, tokens Data) error {
+	if err := checkFinite(what+" granted", granted...); err != nil {
+		return err
+	}
+	if err := checkFinite(what+" tokens", tokens); err != nil {
+		return err
+	}
+	if err := checkGranted(what, cfg, requested, granted); err != nil {
+		return err
+	}
+	if cfg.estimationErrors() {
+		// The corrections can put the bucket into debt, or refund tokens beyond
+		// the max burst; only the bound that takes into account the tokens left
+		// in the bucket applies.
+		return checkCharged(what, cfg, granted, tokens)
+	}
+	// The ideal bucket can never give out more than the initial burst plus the
+	// refill rate.
+	cumulative := granted.Aggregate(cfg).Cumulative(cfg)
+	maxTokens := math.Max(cfg.InitialBurst, cfg.MaxBurst)
+	for i := range cumulative {
+		limit := cfg.InitialBurst + cfg.RatePerSec*cfg.TimeForTick(i+1).Seconds()
+		if cumulative[i] > limit+tolerance(limit) {
+			return fmt.Errorf("%s: total granted %v exceeds %v at tick %d", what, cumulative[i], limit, i)
+		}
+		if tokens[i] > maxTokens+tolerance(maxTokens) {
+			return fmt.Errorf("%s: tokens %v exceed %v at tick %d", what, tokens[i], maxTokens, i)
+		}
+	}
+	return nil
+}
+
+// checkDistributed verifies the results of the distributed token bucket.
+func checkDistributed(
+	what string, cfg *Config, requested, granted PerNodeData, tokens Data,
+) error {
+	if err := checkFinite(what+" granted", granted...); err != nil {
+		return err
+	}
+	if err := checkFinite(what+" global tokens", tokens); err != nil {
+		return err
+	}
+	if err := checkGranted(what, cfg, requested, granted); err != nil {
+		return err
+	}
+	if cfg.estimationErrors() {
+		// The corrections are applied to the local buckets, which can go into
+		// debt; the local tokens aren't part of the results, so we can't bound
+		// the amount charged.
+		return nil
+	}
+	return checkCharged(what, cfg, granted, tokens)
+}
+
+// checkCharged verifies that the amount charged to the bucket (see charged)
+// doesn't exceed what was taken out of it: the bucket can go into debt, but
+// the total is at most the initial burst plus the refills, minus whatever is
+// left in the bucket.
+func checkCharged(what string, cfg *Config, granted PerNodeData, tokens Data) error {
+	cumulative := charged(cfg, granted)
+	for i := 0; i+1 < len(cumulative); i++ {
+		limit := cfg.InitialBurst + cfg.RatePerSec*cfg.TimeForTick(i+2).Seconds() - tokens[i+1]
+		if cumulative[i] > limit+tolerance(limit) {
+			return fmt.Errorf("%s: total granted %v exceeds %v at tick %d", what, cumulative[i], limit, i)
+		}
+	}
+	return nil
+}
+
+// charged returns the total amount charged to the bucket by each tick: the
+// amount granted, plus the corrections for the estimation errors that are due
+// by then (see corrections).
+func charged(cfg *Config, granted PerNodeData) Data {
+	res := granted.Aggregate(cfg).Cumulative(cfg)
+	if !cfg.estimationErrors() {
+		return res
+	}
+	lagTicks := cfg.TickForTime(cfg.CorrectionLag)
+	for n := range granted {
+		errors := estimateErrors(cfg, n)
+		var sum float64
+		for i := range res {
+			if t := i - lagTicks; t >= 0 {
+				sum += granted[n][t] * cfg.Tick.Seconds() * errors[t]
+			}
+			res[i] += sum
+		}
+	}
+	return res
+}
+
+// tolerance returns the allowed floating point error when comparing against
+// the given value.
+func tolerance(v float64) float64 {
+	return 1e-6 + 1e-9*math.Abs(v)
+}
+
+func checkFinite(what string, data ...Data) error {
+	for n := range data {
+		for i, v := range data[n] {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%s: invalid value %v for n%d at tick %d", what, v, n+1, i)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGranted verifies that each node was never granted a negative amount,
+// and that the total amount granted never exceeds the total amount requested.
+func checkGranted(what string, cfg *Config, requested, granted PerNodeData) error {
+	for n := range granted {
+		for i, v := range granted[n] {
+			if v < -tolerance(0) {
+				return fmt.Errorf("%s: negative grant %v for n%d at tick %d", what, v, n+1, i)
+			}
+		}
+		req := requested[n].Cumulative(cfg)
+		gr := granted[n].Cumulative(cfg)
+		for i := range gr {
+			if gr[i] > req[i]+tolerance(req[i]) {
+				return fmt.Errorf(
+					"%s: total granted %v exceeds total requested %v for n%d at tick %d",
+					what, gr[i], req[i], n+1, i,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// MinimizeInput simplifies an input for which check returns an error, while
+// preserving the failure. It removes nodes and terms and resets settings to
+// their defaults, for as long as the same kind of failure persists (errors are
+// considered the same if they only differ in numeric values).
+func MinimizeInput(in Input, check func(Input) error) Input {
+	err := check(in)
+	if err == nil {
+		return in
+	}
+	signature := errorSignature(err)
+	in = in.clone()
+	fails := func(candidate Input) bool {
+		err := check(candidate)
+		return err != nil && errorSignature(err) == signature
+	}
+	for progress := true; progress; {
+		progress = false
+
+		// Try to remove variants, groups and nodes.
+		for i := 0; i < len(in.Variants); i++ {
+			c := in.clone()
+			c.Variants = append(c.Variants[:i], c.Variants[i+1:]...)
+			if fails(c) {
+				in, progress = c, true
+				i--
+			}
+		}
+		for i := 0; i < len(in.Groups); i++ {
+			c := in.clone()
+			c.Groups = append(c.Groups[:i], c.Groups[i+1:]...)
+			if c.NumNodes() > 0 && fails(c) {
+				in, progress = c, true
+				i--
+			}
+		}
+		for i := 0; i < len(in.Nodes); i++ {
+			c := in.clone()
+			c.Nodes = append(c.Nodes[:i], c.Nodes[i+1:]...)
+			if c.NumNodes() > 0 && fails(c) {
+				in, progress = c, true
+				i--
+			}
+		}
+
+		// Try to simplify groups to a single instance.
+		for i := range in.Groups {
+			if in.Groups[i].Count > 1 {
+				c := in.clone()
+				c.Groups[i].Count = 1
+				if fails(c) {
+					in, progress = c, true
+				}
+			}
+		}
+
+		// Try to stop using templates (and remove them once they are unused).
+		for n := range in.Nodes {
+			if len(in.Nodes[n].Templates) > 0 {
+				c := in.clone()
+				c.Nodes[n].Templates = nil
+				if fails(c) {
+					in, progress = c, true
+				}
+			}
+		}
+		for i := range in.Groups {
+			if len(in.Groups[i].Templates) > 0 {
+				c := in.clone()
+				c.Groups[i].Templates = nil
+				if fails(c) {
+					in, progress = c, true
+				}
+			}
+		}
+		if in.Templates != nil {
+			c := in.clone()
+			c.Templates = nil
+			if fails(c) {
+				in, progress = c, true
+			}
+		}
+
+		// Try to remove terms.
+		for n := range in.Nodes {
+			for i := 0; i < len(in.Nodes[n].Terms); i++ {
+				c := in.clone()
+				c.Nodes[n].Terms = append(c.Nodes[n].Terms[:i], c.Nodes[n].Terms[i+1:]...)
+				if fails(c) {
+					in, progress = c, true
+					i--
+				}
+			}
+		}
+		for g := range in.Groups {
+			for i := 0; i < len(in.Groups[g].Terms); i++ {
+				c := in.clone()
+				c.Groups[g].Terms = append(c.Groups[g].Terms[:i], c.Groups[g].Terms[i+1:]...)
+				if fails(c) {
+					in, progress = c, true
+					i--
+				}
+			}
+		}
+
+		// Try to simplify term parameters, by zeroing or rounding them.
+		for n := range in.Nodes {
+			for i := range in.Nodes[n].Terms {
+				for _, f := range termFields {
+					for _, simplify := range []func(float64) float64{
+						func(float64) float64 { return 0 },
+						math.Round,
+					} {
+						c := in.clone()
+						t := &c.Nodes[n].Terms[i]
+						v := f(t)
+						if nv := simplify(*v); nv != *v {
+							*v = nv
+							if fails(c) {
+								in, progress = c, true
+							}
+						}
+					}
+				}
+			}
+		}
+
+		// Try to reset config settings to the defaults.
+		for _, f := range configFields {
+			c := in.clone()
+			if f(&c.Config, &DefaultConfig) && fails(c) {
+				in, progress = c, true
+			}
+		}
+
+		// Try to shorten the timeframe.
+		if in.Config.NumTicks() > 1 {
+			c := in.clone()
+			c.Config.Timeframe = c.Config.TimeForTick(c.Config.NumTicks() / 2)
+			if fails(c) {
+				in, progress = c, true
+			}
+		}
+	}
+	return in
+}
+
+var numberRegexp = regexp.MustCompile(`[-+]?[0-9][0-9.e+-]*|NaN|[-+]?Inf`)
+
+func errorSignature(err error) string {
+	return numberRegexp.ReplaceAllString(err.Error(), "#")
+}
+
+func (in Input) clone() Input {
+	nodes := make([]FuncDesc, len(in.Nodes))
+	for i := range nodes {
+		nodes[i].Templates = append([]string(nil), in.Nodes[i].Templates...)
+		nodes[i].Terms = append([]FuncTerm(nil), in.Nodes[i].Terms...)
+	}
+	in.Nodes = nodes
+	if in.Groups != nil {
+		groups := make([]NodeGroup, len(in.Groups))
+		for i := range groups {
+			groups[i] = in.Groups[i]
+			groups[i].Templates = append([]string(nil), in.Groups[i].Templates...)
+			groups[i].Terms = append([]FuncTerm(nil), in.Groups[i].Terms...)
+		}
+		in.Groups = groups
+	}
+	if in.Templates != nil {
+		templates := make(map[string][]FuncTerm, len(in.Templates))
+		for name, terms := range in.Templates {
+			templates[name] = append([]FuncTerm(nil), terms...)
+		}
+		in.Templates = templates
+	}
+	// The variant overrides are not modified, so they can be shared.
+	in.Variants = append([]Variant(nil), in.Variants...)
+	return in
+}
+
+// termFields are accessors for the numeric FuncTerm fields that can be
+// simplified (Smoothness is excluded since it can't be zero).
+var termFields = []func(t *FuncTerm) *float64{
+	func(t *FuncTerm) *float64 { return &t.Start },
+	func(t *FuncTerm) *float64 { return &t.Duration },
+	func(t *FuncTerm) *float64 { return &t.Value },
+	func(t *FuncTerm) *float64 { return &t.Delta },
+	func(t *FuncTerm) *float64 { return &t.Period },
+	func(t *FuncTerm) *float64 { return &t.Phase },
+	func(t *FuncTerm) *float64 { return &t.Amplitude },
+}
+
+// configFields are functions that each reset a Config setting to the value in
+// the given default, returning false if the setting already had that value.
+var configFields = []func(c, def *Config) bool{
+	func(c, def *Config) bool { return resetField(&c.Tick, def.Tick) },
+	func(c, def *Config) bool { return resetField(&c.RatePerSec, def.RatePerSec) },
+	func(c, def *Config) bool { return resetField(&c.InitialBurst, def.InitialBurst) },
+	func(c, def *Config) bool { return resetField(&c.MaxBurst, def.MaxBurst) },
+	func(c, def *Config) bool { return resetField(&c.TargetRefillPeriod, def.TargetRefillPeriod) },
+	func(c, def *Config) bool { return resetField(&c.InitialRefillAmount, def.InitialRefillAmount) },
+	func(c, def *Config) bool { return resetField(&c.MinRefillAmount, def.MinRefillAmount) },
+	func(c, def *Config) bool { return resetField(&c.MaxRefillAmount, def.MaxRefillAmount) },
+	func(c, def *Config) bool { return resetField(&c.RefillFraction, def.RefillFraction) },
+	func(c, def *Config) bool { return resetField(&c.PreRequestTime, def.PreRequestTime) },
+	func(c, def *Config) bool { return resetField(&c.EWMAFactor, def.EWMAFactor) },
+	func(c, def *Config) bool { return resetField(&c.BacklogTimeScale, def.BacklogTimeScale) },
+	func(c, def *Config) bool { return resetField(&c.BacklogFactorLog10, def.BacklogFactorLog10) },
+	func(c, def *Config) bool { return resetField(&c.Budget, def.Budget) },
+	func(c, def *Config) bool { return resetField(&c.BudgetPeriod, def.BudgetPeriod) },
+	func(c, def *Config) bool { return resetField(&c.BudgetPolicy, def.BudgetPolicy) },
+	func(c, def *Config) bool { return resetField(&c.BudgetReducedRate, def.BudgetReducedRate) },
+	func(c, def *Config) bool { return resetField(&c.GlobalServiceRate, def.GlobalServiceRate) },
+	func(c, def *Config) bool { return resetField(&c.GlobalLatency, def.GlobalLatency) },
+	func(c, def *Config) bool { return resetField(&c.GlobalConflictProb, def.GlobalConflictProb) },
+	func(c, def *Config) bool { return resetField(&c.GlobalRetryBackoff, def.GlobalRetryBackoff) },
+	func(c, def *Config) bool { return resetField(&c.GlobalMaxRetries, def.GlobalMaxRetries) },
+	func(c, def *Config) bool { return resetField(&c.EstimateErrorMean, def.EstimateErrorMean) },
+	func(c, def *Config) bool { return resetField(&c.EstimateErrorStdDev, def.EstimateErrorStdDev) },
+	func(c, def *Config) bool { return resetField(&c.EstimateErrorDist, def.EstimateErrorDist) },
+	func(c, def *Config) bool { return resetField(&c.CorrectionLag, def.CorrectionLag) },
+	func(c, def *Config) bool { return resetField(&c.RUPerReadBatch, def.RUPerReadBatch) },
+	func(c, def *Config) bool { return resetField(&c.RUPerReadMiB, def.RUPerReadMiB) },
+	func(c, def *Config) bool { return resetField(&c.RUPerWriteBatch, def.RUPerWriteBatch) },
+	func(c, def *Config) bool { return resetField(&c.RUPerWriteMiB, def.RUPerWriteMiB) },
+	func(c, def *Config) bool { return resetField(&c.RUPerSQLCPUSec, def.RUPerSQLCPUSec) },
+}
+
+func resetField(field interface{}, def interface{}) bool {
+	switch f := field.(type) {
+	case *float64:
+		if *f == def.(float64) {
+			return false
+		}
+		*f = def.(float64)
+	case *time.Duration:
+		if *f == def.(time.Duration) {
+			return false
+		}
+		*f = def.(time.Duration)
+	case *int:
+		if *f == def.(int) {
+			return false
+		}
+		*f = def.(int)
+	case *string:
+		if *f == def.(string) {
+			return false
+		}
+		*f = def.(string)
+	default:
+		panic("unsupported field type")
+	}
+	return true
+}
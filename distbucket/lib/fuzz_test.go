@@ -0,0 +1,26 @@
+package lib
+
+import "testing"
+
+// TestFuzzSeeds runs CheckInput on the inputs generated from a fixed set of
+// seeds (the same check as "distbucket fuzz -seed 1 -n 300").
+func TestFuzzSeeds(t *testing.T) {
+	for seed := int64(1); seed <= 300; seed++ {
+		if err := CheckInput(NewWorkloadGen(seed).Input()); err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+	}
+}
+
+// FuzzDistTokenBucket runs CheckInput on the inputs generated from random
+// seeds; use "go test -fuzz FuzzDistTokenBucket" to run it.
+func FuzzDistTokenBucket(f *testing.F) {
+	for seed := int64(1); seed <= 10; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		if err := CheckInput(NewWorkloadGen(seed).Input()); err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+	})
+}
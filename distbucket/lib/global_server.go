@@ -0,0 +1,287 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// This file implements the global bucket capacity model. In production, a
+// request to the global bucket is a KV transaction on a single row: it has a
+// finite throughput, its latency grows with the load, and concurrent
+// transactions can conflict and have to be retried. The model is enabled by
+// the Global* config settings; without it, the requests are processed
+// instantly.
+//
+// The global bucket is a single server which processes GlobalServiceRate
+// requests per second, in FIFO order. A request conflicts with each other
+// request that is waiting in the queue with probability GlobalConflictProb; a
+// conflicting request is retried after GlobalRetryBackoff (at the end of the
+// queue), up to GlobalMaxRetries times, after which it fails (and the node can
+// send a new request). The response arrives at the node GlobalLatency after
+// the request is processed.
+
+// globalServerModel returns true if the config enables the global bucket
+// capacity model.
+func (c *Config) globalServerModel() bool {
+	return c.GlobalServiceRate > 0 || c.GlobalLatency > 0 || c.GlobalConflictProb > 0
+}
+
+// pendingRequest is a request that was sent to the global bucket but was not
+// processed yet.
+type pendingRequest struct {
+	nodeIdx int
+	// sentTick is the tick when the node sent the request.
+	sentTick int
+	// readyTick is the tick from which the request can be processed (it is
+	// later than sentTick after a retry).
+	readyTick int
+	retries   int
+
+	prevShares float64
+	shares     float64
+	amount     float64
+}
+
+// refillResponse is a response from the global bucket, which is delivered to
+// the node at the given tick.
+type refillResponse struct {
+	nodeIdx      int
+	tick         int
+	sentTick     int
+	shares       float64
+	granted      float64
+	deadlineTick int
+	// failed is set if the request was not processed (because of conflicts).
+	failed bool
+}
+
+// serverStats contains statistics about the processing of the requests to the
+// global bucket.
+type serverStats struct {
+	// queued is the number of requests waiting to be processed at each tick.
+	queued Data
+	// latency is the average latency (from the time the request is sent until
+	// the response is received) of the responses received at each tick; the
+	// ticks without responses have the previous value.
+	latency Data
+	// latencies contains the latency of each response, in seconds.
+	latencies []float64
+	conflicts int
+	failed    int
+}
+
+// globalServer processes the requests to the global bucket with finite
+// capacity (see Config.globalServerModel).
+type globalServer struct {
+	queue     []pendingRequest
+	responses []refillResponse
+	// capacity is the number of requests that can be processed (it accumulates
+	// while there are no requests, up to one tick's worth).
+	capacity float64
+	r        *rand.Rand
+	stats    serverStats
+}
+
+// newGlobalServer returns nil if the config doesn't enable the model.
+func newGlobalServer(cfg *Config) *globalServer {
+	if !cfg.globalServerModel() {
+		return nil
+	}
+	return &globalServer{
+		r: rand.New(rand.NewSource(1)),
+		stats: serverStats{
+			queued:  ZeroData(cfg),
+			latency: ZeroData(cfg),
+		},
+	}
+}
+
+// send queues a request.
+func (s *globalServer) send(req pendingRequest) {
+	s.queue = append(s.queue, req)
+}
+
+// nextReady returns the index of the first request in the queue that can be
+// processed at the given tick, or -1 if there is none.
+func (s *globalServer) nextReady(now int) int {
+	for i := range s.queue {
+		if s.queue[i].readyTick <= now {
+			return i
+		}
+	}
+	return -1
+}
+
+// process processes the queued requests, as many as the capacity allows, and
+// queues the responses.
+func (s *globalServer) process(cfg *Config, gb *globalBucket, now int) {
+	perTick := cfg.GlobalServiceRate * cfg.Tick.Seconds()
+	if cfg.GlobalServiceRate == 0 {
+		perTick = math.Inf(1)
+	}
+	s.capacity = math.Min(s.capacity+perTick, math.Max(perTick, 1))
+	latencyTicks := cfg.TickForTime(cfg.GlobalLatency)
+	for s.capacity >= 1 {
+		i := s.nextReady(now)
+		if i < 0 {
+			break
+		}
+		req := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.capacity--
+
+		// The request conflicts with any of the other requests that are waiting.
+		var concurrent int
+		for j := range s.queue {
+			if s.queue[j].readyTick <= now {
+				concurrent++
+			}
+		}
+		if conflictProb := 1 - math.Pow(1-cfg.GlobalConflictProb, float64(concurrent)); s.r.Float64() < conflictProb {
+			s.stats.conflicts++
+			if req.retries < cfg.GlobalMaxRetries {
+				req.retries++
+				backoff := cfg.TickForTime(cfg.GlobalRetryBackoff)
+				if backoff < 1 {
+					backoff = 1
+				}
+				req.readyTick = now + backoff
+				s.queue = append(s.queue, req)
+				continue
+			}
+			s.stats.failed++
+			s.responses = append(s.responses, refillResponse{
+				nodeIdx:  req.nodeIdx,
+				tick:     now + latencyTicks,
+				sentTick: req.sentTick,
+				failed:   true,
+			})
+			continue
+		}
+
+		granted, deadlineTick := gb.processRequest(cfg, now, req.nodeIdx, req.prevShares, req.shares, req.amount)
+		s.responses = append(s.responses, refillResponse{
+			nodeIdx:      req.nodeIdx,
+			tick:         now + latencyTicks,
+			sentTick:     req.sentTick,
+			shares:       req.shares,
+			granted:      granted,
+			deadlineTick: deadlineTick,
+		})
+	}
+	s.stats.queued[now] = float64(len(s.queue))
+}
+
+// deliver returns the responses that are received at the given tick.
+func (s *globalServer) deliver(cfg *Config, now int) []refillResponse {
+	var res []refillResponse
+	remaining := s.responses[:0]
+	for _, r := range s.responses {
+		if r.tick <= now {
+			res = append(res, r)
+		} else {
+			remaining = append(remaining, r)
+		}
+	}
+	s.responses = remaining
+
+	if now > 0 {
+		s.stats.latency[now] = s.stats.latency[now-1]
+	}
+	if len(res) > 0 {
+		var sum float64
+		for _, r := range res {
+			latency := float64(now-r.sentTick) * cfg.Tick.Seconds()
+			s.stats.latencies = append(s.stats.latencies, latency)
+			sum += latency
+		}
+		s.stats.latency[now] = sum / float64(len(res))
+	}
+	return res
+}
+
+// serverStat is a statistic of the processing of the requests to the global
+// bucket.
+type serverStat struct {
+	name    string
+	unit    string
+	compute func(stats *serverStats) float64
+}
+
+var serverStatList = []serverStat{
+	{
+		name: "max queued requests",
+		compute: func(stats *serverStats) float64 {
+			return maxValue(stats.queued)
+		},
+	},
+	latencyQuantile("median", 0.5),
+	latencyQuantile("p99", 0.99),
+	latencyQuantile("max", 1),
+	{
+		name: "conflicts",
+		compute: func(stats *serverStats) float64 {
+			return float64(stats.conflicts)
+		},
+	},
+	{
+		name: "failed requests",
+		compute: func(stats *serverStats) float64 {
+			return float64(stats.failed)
+		},
+	},
+}
+
+func latencyQuantile(name string, q float64) serverStat {
+	return serverStat{
+		name: fmt.Sprintf("request latency (%s)", name),
+		unit: "s",
+		compute: func(stats *serverStats) float64 {
+			return quantile(append([]float64(nil), stats.latencies...), q)
+		},
+	}
+}
+
+// capacityTable returns a table with the server statistics for the runs that
+// use the capacity model (or false if there are none).
+func capacityTable(names []string, runs []*run) (Table, bool) {
+	t := Table{Title: "Global bucket capacity"}
+	var withServer []*run
+	for i, r := range runs {
+		if r.server != nil {
+			t.Columns = append(t.Columns, names[i])
+			withServer = append(withServer, r)
+		}
+	}
+	if len(withServer) == 0 {
+		return Table{}, false
+	}
+	for _, s := range serverStatList {
+		row := TableRow{Name: s.name, Unit: s.unit}
+		for _, r := range withServer {
+			row.Values = append(row.Values, s.compute(r.server))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, true
+}
+
+// capacityChart returns a chart with the queued requests and the request
+// latency for the runs that use the capacity model.
+func capacityChart(names []string, runs []*run) Chart {
+	c := Chart{
+		Title: "Global bucket queue",
+		Units: []Unit{{Name: "requests"}, {Name: "s"}},
+	}
+	for i, r := range runs {
+		if r.server == nil {
+			continue
+		}
+		c.Series = append(c.Series,
+			Series{Name: names[i] + " queued", Unit: "requests", Width: 1, Data: r.server.queued},
+			Series{Name: names[i] + " latency", Unit: "s", Width: 0.5, Data: r.server.latency},
+		)
+	}
+	return c
+}
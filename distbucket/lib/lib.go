@@ -1,33 +1,143 @@
 package lib
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
-	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 // This struct is the input to the library.
 type Input struct {
+	// Version is the version of the input schema (see CurrentVersion).
+	Version int `yaml:",omitempty"`
+
 	Config Config
-	Nodes  []FuncDesc
+	Nodes  []FuncDesc `yaml:",omitempty"`
+	// Groups define additional nodes, which are added after Nodes.
+	Groups []NodeGroup `yaml:",omitempty"`
+	// Templates contains named lists of terms which can be used by nodes and
+	// groups.
+	Templates map[string][]FuncTerm `yaml:",omitempty"`
+
+	// Variants, if set, are compared against each other instead of showing the
+	// results of each algorithm.
+	Variants []Variant `yaml:",omitempty"`
+
+	// Output contains settings for optional outputs.
+	Output OutputSettings `yaml:",omitempty"`
+}
+
+// OutputSettings enables optional outputs, which are off by default to keep
+// the default view (and the output size) small.
+type OutputSettings struct {
+	// EventLog enables recording of the requests to the global bucket; they
+	// are returned in Output.Events and shown as chart markers.
+	EventLog bool `yaml:"event_log,omitempty"`
+
+	// Charts lists optional charts of the internal state of the distributed
+	// token bucket (local_tokens, refill_rate, ewma, shares, weighted_backlog,
+	// global_debt, shares_sum).
+	Charts []string `yaml:",omitempty"`
+
+	// Resolution, if set, is the maximum number of points in each series; the
+	// series are downsampled using the given method (see Output.Downsample).
+	// The simulation itself always uses all the ticks.
+	Resolution   int    `yaml:",omitempty"`
+	Downsampling string `yaml:",omitempty"`
+}
+
+// validate checks the output settings; the paths of the returned errors are
+// relative to the output settings.
+func (o *OutputSettings) validate(in *Input) InputErrors {
+	var errs InputErrors
+	seen := make(map[string]bool)
+	for i, key := range o.Charts {
+		path := fmt.Sprintf("charts[%d]", i)
+		if findStateChart(key) == nil {
+			errs.Errorf(path, "unknown chart '%s' (must be one of: %s)", key, stateChartKeys())
+		} else if seen[key] {
+			errs.Errorf(path, "duplicate chart '%s'", key)
+		}
+		seen[key] = true
+	}
+	switch {
+	case o.Resolution == 0:
+		if o.Downsampling != "" {
+			errs.Warningf("downsampling", "ignored because the resolution is not set")
+		}
+		if n := in.Config.NumTicks(); n > maxOutputPoints {
+			errs.Warningf("", "%d points per series; consider setting the resolution", n)
+		}
+	case o.Resolution < minDownsamplePoints:
+		errs.Errorf("resolution", "invalid resolution %d (must be at least %d)", o.Resolution, minDownsamplePoints)
+	}
+	switch o.Downsampling {
+	case "", DownsampleLTTB, DownsampleMinMax:
+	default:
+		errs.Errorf(
+			"downsampling", "unknown method '%s' (must be one of: %s, %s)",
+			o.Downsampling, DownsampleLTTB, DownsampleMinMax,
+		)
+	}
+	if len(in.Variants) > 0 {
+		if o.EventLog {
+			errs.Warningf("event_log", "not supported when comparing variants")
+		}
+		if len(o.Charts) > 0 {
+			errs.Warningf("charts", "not supported when comparing variants")
+		}
+	}
+	return errs
 }
 
 // This struct is the output of the library.
 type Output struct {
 	TimeAxis []float64
 
-	Charts []Chart
+	Charts   []Chart
+	Scatters []Scatter
+	Tables   []Table
 
+	// Events contains the requests to the global bucket made by the
+	// distributed token bucket, if enabled in the input (see OutputSettings).
+	Events EventLog
+
+	// Error summarizes all problems of SeverityError; if set, there are no
+	// charts.
 	Error string
+	// Errors contains all problems with the input, including warnings.
+	Errors []InputError
 }
 
 type Chart struct {
+	Title   string
+	Units   []Unit
+	Series  []Series
+	Markers []Marker
+}
+
+// Marker annotates a point in time on a chart.
+type Marker struct {
+	Time  float64
+	Label string
+	// Series is the name of the series that the marker relates to, if any.
+	Series string
+}
+
+// Scatter is a plot of points with arbitrary coordinates (unlike Chart, which
+// plots values over time).
+type Scatter struct {
 	Title  string
-	Units  []Unit
-	Series []Series
+	XLabel string
+	YLabel string
+	Points []ScatterPoint
+}
+
+type ScatterPoint struct {
+	Name string
+	X, Y float64
 }
 
 type Unit struct {
@@ -42,216 +152,201 @@ type Series struct {
 	Data  []float64
 }
 
-func Test() (int, error) {
-	return 1, errors.New("lol")
+// ParseInput parses the input, in any of the supported formats (see
+// DetectFormat); any settings not specified in the input are set to the values
+// in DefaultConfig. Inputs using older versions of the schema are migrated to
+// the current version. Parsing errors are returned as InputErrors, with their
+// positions in the input.
+func ParseInput(input string) (Input, error) {
+	return ParseInputFormat(input, FormatAuto)
 }
 
-func throw(format string, args ...interface{}) {
-	panic(fmt.Errorf(format, args...))
+// ParseInputFormat is like ParseInput, for a given format.
+func ParseInputFormat(inputText string, format Format) (Input, error) {
+	input, errs := parseInputFormat(inputText, format)
+	if errs.HasErrors() {
+		errs = errs.Filter(SeverityError)
+		errs.locate(inputPositions(inputText, format))
+		return Input{}, errs
+	}
+	return input, nil
 }
 
-// Process takes the input parameters and generates the output graphs.
-func Process(inputYAML string) (result Output) {
-	// Catch any errors.
-	defer func() {
-		if obj := recover(); obj != nil {
-			if err, isErr := obj.(error); isErr {
-				result = Output{
-					Error: err.Error(),
-				}
-			}
-		}
-	}()
-
+// parseInput is like ParseInput, but it also returns any warnings.
+func parseInput(inputYAML string) (Input, InputErrors) {
 	input := Input{
 		Config: DefaultConfig,
 	}
 	if err := yaml.UnmarshalStrict([]byte(inputYAML), &input); err != nil {
-		throw("Error parsing input YAML: %v\n", err)
+		return Input{}, yamlErrors(inputYAML, err)
 	}
-	cfg := &input.Config
-	if cfg.TargetRefillPeriodSecs != 0 {
-		cfg.TargetRefillPeriod = time.Duration(cfg.TargetRefillPeriodSecs * float64(time.Second))
-	}
-	if cfg.BacklogTimeScaleSecs != 0 {
-		cfg.BacklogTimeScale = time.Duration(cfg.BacklogTimeScaleSecs * float64(time.Second))
+	errs := migrateInput(&input, inputYAML)
+	if errs.HasErrors() {
+		return Input{}, errs
 	}
+	input.Config.applySecs()
+	return input, errs
+}
 
-	requested := MakePerNodeData(cfg, len(input.Nodes))
-	for i := range requested {
-		requested[i] = DataFromFuncDesc(cfg, input.Nodes[i])
-		for j := range requested[i] {
-			if requested[i][j] < 0 {
-				requested[i][j] = 0
-			}
-		}
+// YAML returns the input in YAML form, suitable for ParseInput.
+func (in Input) YAML() (string, error) {
+	in.Version = CurrentVersion
+	in.Config.setSecs()
+	out, err := yaml.Marshal(&in)
+	if err != nil {
+		return "", err
 	}
-	aggregateRequested := requested.Aggregate(cfg)
-
-	grantedDist, tokensDist := DistTokenBucket3(cfg, requested)
-	aggregateDist := grantedDist.Aggregate(cfg)
+	return string(out), nil
+}
 
-	grantedIdeal, tokensIdeal := TokenBucket(cfg, requested)
-	aggregateIdeal := grantedIdeal.Aggregate(cfg)
+// Requested returns the requested rate for each node (including the instances
+// of each group), as defined by the node functions (with negative values
+// clamped to zero). Terms that describe the rate of an operation are converted
+// to RU/s (see FuncTerm.Operation). Problems with the node functions are
+// returned as InputErrors.
+func (in *Input) Requested() (PerNodeData, error) {
+	requested, _, err := in.requested()
+	return requested, err
+}
 
-	var graphMax float64
-	for _, v := range aggregateRequested {
-		graphMax = math.Max(graphMax, v)
+// requested is like Requested; it also returns the breakdown by operation, if
+// any of the terms uses an operation.
+func (in *Input) requested() (PerNodeData, *costBreakdown, error) {
+	cfg := &in.Config
+	nodes, errs := in.expandNodes()
+	if errs.HasErrors() {
+		return nil, nil, errs
 	}
-	//for _, v := range aggregateDist {
-	//	graphMax = math.Max(graphMax, v)
-	//}
-	//for _, v := range aggregateIdeal {
-	//	graphMax = math.Max(graphMax, v)
-	//}
-
-	nodeSeries := make([]Series, len(requested))
-	for i := range nodeSeries {
-		nodeSeries[i] = Series{
-			Name:  fmt.Sprintf("n%d", i+1),
-			Unit:  "RU/s",
-			Width: 1,
-			Data:  requested[i],
+	requested := MakePerNodeData(cfg, len(nodes))
+	// The operation rates of the current node; these are clamped separately.
+	ops := MakePerNodeData(cfg, len(operations))
+	var breakdown *costBreakdown
+	for i := range nodes {
+		for _, f := range nodes[i].terms {
+			if f.Operation != "" && breakdown == nil {
+				breakdown = &costBreakdown{
+					direct: ZeroData(cfg),
+					ops:    MakePerNodeData(cfg, len(operations)),
+					used:   make([]bool, len(operations)),
+				}
+			}
 		}
 	}
-
-	out := Output{
-		TimeAxis: cfg.TimeAxis(),
-	}
-
-	out.Charts = append(out.Charts, Chart{
-		Title: "Requested",
-		Units: []Unit{
-			{
-				Name:       "RU/s",
-				FixedRange: []float64{0, graphMax},
-			},
-		},
-		Series: append(nodeSeries, Series{
-			Name:  "aggregate",
-			Unit:  "RU/s",
-			Width: 2,
-			Data:  aggregateRequested,
-		}),
-	})
-
-	nodeSeries = make([]Series, len(requested))
-	for i := range nodeSeries {
-		g := grantedDist[i]
-		if cfg.Smoothing {
-			g = g.Smooth(cfg, 0.1)
+	// Terms from templates and groups are shared by multiple nodes; only report
+	// each problem once.
+	seen := make(map[InputError]bool)
+	for i := range requested {
+		used := make([]bool, len(operations))
+		for k, f := range nodes[i].terms {
+			d := requested[i]
+			if op := findOperation(f.Operation); op >= 0 {
+				d = ops[op]
+				used[op] = true
+			}
+			if err := d.AddFuncTerm(cfg, f); err != nil {
+				for _, e := range toInputErrors(err).withPrefix(nodes[i].termPaths[k]) {
+					if !seen[e] {
+						seen[e] = true
+						errs = append(errs, e)
+					}
+				}
+			}
 		}
-		nodeSeries[i] = Series{
-			Name:  fmt.Sprintf("n%d", i+1),
-			Unit:  "RU/s",
-			Width: 1,
-			Data:  g,
+		clampNegative(requested[i])
+		if breakdown != nil {
+			for t := range requested[i] {
+				breakdown.direct[t] += requested[i][t]
+			}
 		}
+		for op := range used {
+			if !used[op] {
+				continue
+			}
+			clampNegative(ops[op])
+			cost := operations[op].cost(cfg)
+			for t, v := range ops[op] {
+				requested[i][t] += v * cost
+				breakdown.ops[op][t] += v * cost
+				ops[op][t] = 0
+			}
+			breakdown.used[op] = true
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
 	}
+	return requested, breakdown, nil
+}
 
-	out.Charts = append(out.Charts, Chart{
-		Title: "Granted (distributed token bucket)",
-		Units: []Unit{
-			{
-				Name:       "RU/s",
-				FixedRange: []float64{0, graphMax},
-			},
-			{
-				Name: "RU",
-			},
-		},
-		Series: append(nodeSeries,
-			Series{
-				Name:  "aggregate",
-				Unit:  "RU/s",
-				Width: 2.5,
-				Data:  aggregateDist,
-			},
-			Series{
-				Name:  "global tokens",
-				Unit:  "RU",
-				Width: 0.5,
-				Data:  tokensDist,
-			},
-		),
-	})
-
-	nodeSeries = make([]Series, len(requested))
-	for i := range nodeSeries {
-		g := grantedIdeal[i]
-		if cfg.Smoothing {
-			g = g.Smooth(cfg, 0.1)
+func clampNegative(d Data) {
+	for i := range d {
+		if d[i] < 0 {
+			d[i] = 0
 		}
-		nodeSeries[i] = Series{
-			Name:  fmt.Sprintf("n%d", i+1),
-			Unit:  "RU/s",
-			Width: 1,
-			Data:  g,
+	}
+}
+
+func Test() (int, error) {
+	return 1, errors.New("lol")
+}
+
+// internalError is the panic value used by throw.
+type internalError struct {
+	err error
+}
+
+func (e internalError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.err)
+}
+
+// throw panics with an internalError; it is used when an invariant of the
+// simulation is violated (and never for problems with the input, which are
+// reported as InputErrors).
+func throw(format string, args ...interface{}) {
+	panic(internalError{err: fmt.Errorf(format, args...)})
+}
+
+// Process takes the input parameters (in any of the supported formats; see
+// DetectFormat) and generates the output graphs. Any problems with the input
+// are reported in Output.Errors, with their positions in the input.
+func Process(input string) Output {
+	return ProcessFormat(input, FormatAuto)
+}
+
+// ProcessFormat is like Process, for a given format.
+func ProcessFormat(input string, format Format) Output {
+	return ProcessContext(context.Background(), input, format)
+}
+
+// ProcessContext is like ProcessFormat, but the simulation is stopped (with an
+// error) once the context is done.
+func ProcessContext(ctx context.Context, input string, format Format) Output {
+	out, errs := process(ctx, input, format)
+	if len(errs) > 0 {
+		errs.locate(inputPositions(input, format))
+		out.Errors = errs
+		if errs.HasErrors() {
+			out.Error = errs.Filter(SeverityError).Error()
 		}
 	}
+	return out
+}
 
-	out.Charts = append(out.Charts, Chart{
-		Title: "Granted (ideal token bucket)",
-		Units: []Unit{
-			{
-				Name:       "RU/s",
-				FixedRange: []float64{0, graphMax},
-			},
-			{
-				Name: "RU",
-			},
-		},
-		Series: append(nodeSeries,
-			Series{
-				Name:  "aggregate",
-				Unit:  "RU/s",
-				Width: 2.5,
-				Data:  aggregateIdeal,
-			},
-			Series{
-				Name:  "tokens",
-				Unit:  "RU",
-				Width: 0.5,
-				Data:  tokensIdeal,
-			},
-		),
-	})
-
-	// Generate total granted graphs.
-	totalDist := ZeroData(cfg)
-	var sum float64
-	for i := range totalDist {
-		sum += aggregateDist[i]
-		totalDist[i] = sum
+func process(ctx context.Context, inputText string, format Format) (Output, InputErrors) {
+	input, errs := parseInputFormat(inputText, format)
+	if errs.HasErrors() {
+		return Output{}, errs
 	}
-	totalIdeal := ZeroData(cfg)
-	sum = 0
-	for i := range totalIdeal {
-		sum += aggregateIdeal[i]
-		totalIdeal[i] = sum
+	res, runErrs := input.run(ctx, nil /* external */)
+	errs = append(errs, runErrs...)
+	if errs.HasErrors() {
+		return Output{}, errs
 	}
-	out.Charts = append(out.Charts, Chart{
-		Title: "Total granted (vs ideal)",
-		Units: []Unit{
-			{
-				Name: "RU",
-			},
-		},
-		Series: []Series{
-			{
-				Name:  "distributed",
-				Unit:  "RU",
-				Width: 1,
-				Data:  totalDist,
-			},
-			{
-				Name:  "ideal",
-				Unit:  "RU",
-				Width: 1,
-				Data:  totalIdeal,
-			},
-		},
-	})
-
-	return out
+	out := res.Output()
+	if input.Output.Resolution > 0 {
+		if err := out.Downsample(input.Output.Resolution, input.Output.Downsampling); err != nil {
+			return Output{}, append(errs, toInputErrors(err)...)
+		}
+	}
+	return out, errs
 }
@@ -0,0 +1,266 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// Table is a table of values shown alongside the charts. Each row is a named
+// quantity with one value per column.
+type Table struct {
+	Title   string
+	Columns []string
+	Rows    []TableRow
+}
+
+type TableRow struct {
+	Name   string
+	Unit   string
+	Values []float64
+}
+
+// run contains the results of running an algorithm on a workload, along with
+// the results of the ideal token bucket (with the same config) for reference.
+type run struct {
+	cfg       *Config
+	requested PerNodeData
+	granted   PerNodeData
+	tokens    Data
+
+	idealGranted PerNodeData
+
+	// events contains the requests to the global bucket; it is nil if the
+	// algorithm doesn't report them (see makeDistRun).
+	events *EventLog
+	// server is set if the run uses the global bucket capacity model.
+	server *serverStats
+}
+
+func makeRun(cfg *Config, requested PerNodeData, alg Algorithm) *run {
+	r := &run{cfg: cfg, requested: requested}
+	r.granted, r.tokens = alg(cfg, requested)
+	r.idealGranted, _ = TokenBucket(cfg, requested)
+	return r
+}
+
+// makeExternalRun is like makeRun for an ExternalAlgorithm.
+func makeExternalRun(cfg *Config, requested PerNodeData, alg ExternalAlgorithm) (*run, error) {
+	r := &run{cfg: cfg, requested: requested}
+	var err error
+	if r.granted, r.tokens, err = alg(cfg, requested); err != nil {
+		return nil, err
+	}
+	r.idealGranted, _ = TokenBucket(cfg, requested)
+	return r, nil
+}
+
+// makeDistRun is like makeRun for the distributed token bucket, using the given
+// simulation (set up with the same config and requested rates); the run also
+// contains the requests to the global bucket. Returns an error if the
+// simulation was stopped.
+func makeDistRun(cfg *Config, requested PerNodeData, s *Simulation) (*run, error) {
+	s.RecordEvents()
+	for s.Step() {
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("simulation stopped at %s: %v", cfg.TimeForTick(s.Now()), err)
+	}
+	r := &run{cfg: cfg, requested: requested}
+	r.granted, r.tokens = s.Results()
+	if len(requested) == 0 {
+		// Same as DistTokenBucket3.
+		r.tokens = ZeroData(cfg)
+	}
+	events := s.Events()
+	r.events = &events
+	if s.global.server != nil {
+		r.server = &s.global.server.stats
+	}
+	r.idealGranted, _ = TokenBucket(cfg, requested)
+	return r, nil
+}
+
+// metric is a quantity that summarizes a run.
+type metric struct {
+	name    string
+	unit    string
+	compute func(r *run) float64
+	// enabled, if set, returns false if the metric is not relevant for a config;
+	// the metric is only shown if it is relevant for at least one run.
+	enabled func(cfg *Config) bool
+}
+
+var metrics = []metric{
+	{
+		name: "total requested",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return total(r.cfg, r.requested.Aggregate(r.cfg))
+		},
+	},
+	{
+		name: "total granted",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return total(r.cfg, r.granted.Aggregate(r.cfg))
+		},
+	},
+	{
+		name: "granted vs ideal",
+		unit: "%",
+		compute: func(r *run) float64 {
+			ideal := total(r.cfg, r.idealGranted.Aggregate(r.cfg))
+			if ideal == 0 {
+				return 100
+			}
+			return 100 * total(r.cfg, r.granted.Aggregate(r.cfg)) / ideal
+		},
+	},
+	{
+		name: "max overshoot vs ideal",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return math.Max(0, maxValue(r.cumulativeError()))
+		},
+	},
+	{
+		name: "max undershoot vs ideal",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return math.Max(0, -minValue(r.cumulativeError()))
+		},
+	},
+	{
+		name: "RMS error vs ideal",
+		unit: "RU/s",
+		compute: func(r *run) float64 {
+			return r.rmsError()
+		},
+	},
+	{
+		name: "total actual consumption",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return total(r.cfg, ActualConsumption(r.cfg, r.granted).Aggregate(r.cfg))
+		},
+		enabled: (*Config).estimationErrors,
+	},
+	{
+		name: "max actual debt",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return maxDebt(r.cfg, ActualConsumption(r.cfg, r.granted).Aggregate(r.cfg))
+		},
+		enabled: (*Config).estimationErrors,
+	},
+	{
+		name: "min tokens",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return minValue(r.tokens)
+		},
+	},
+	{
+		name: "max tokens",
+		unit: "RU",
+		compute: func(r *run) float64 {
+			return maxValue(r.tokens)
+		},
+	},
+}
+
+// rmsError returns the root mean square of the difference between the granted
+// rate and the rate granted by the ideal token bucket.
+func (r *run) rmsError() float64 {
+	diff := r.granted.Aggregate(r.cfg).Diff(r.cfg, r.idealGranted.Aggregate(r.cfg))
+	var sum float64
+	for _, v := range diff {
+		sum += v * v
+	}
+	if len(diff) == 0 {
+		return 0
+	}
+	return math.Sqrt(sum / float64(len(diff)))
+}
+
+// cumulativeError returns the difference between the total granted and the
+// total granted by the ideal token bucket, at each tick.
+func (r *run) cumulativeError() Data {
+	granted := r.granted.Aggregate(r.cfg).Cumulative(r.cfg)
+	ideal := r.idealGranted.Aggregate(r.cfg).Cumulative(r.cfg)
+	return granted.Diff(r.cfg, ideal)
+}
+
+// metricsTable returns a table with the metrics for each run. If withDeltas is
+// set, the table also contains the differences between each run and the first
+// run.
+func metricsTable(names []string, runs []*run, withDeltas bool) Table {
+	t := Table{
+		Title:   "Metrics",
+		Columns: append([]string(nil), names...),
+	}
+	if withDeltas {
+		for _, name := range names[1:] {
+			t.Columns = append(t.Columns, "Δ "+name)
+		}
+	}
+	for _, m := range metrics {
+		if !m.enabledForAny(runs) {
+			continue
+		}
+		row := TableRow{Name: m.name, Unit: m.unit}
+		for _, r := range runs {
+			row.Values = append(row.Values, m.compute(r))
+		}
+		if withDeltas {
+			for i := 1; i < len(runs); i++ {
+				row.Values = append(row.Values, row.Values[i]-row.Values[0])
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+func (m *metric) enabledForAny(runs []*run) bool {
+	if m.enabled == nil {
+		return true
+	}
+	for _, r := range runs {
+		if m.enabled(r.cfg) {
+			return true
+		}
+	}
+	return false
+}
+
+// total returns the total amount, given a rate.
+func total(cfg *Config, rate Data) float64 {
+	var sum float64
+	for _, v := range rate {
+		sum += v
+	}
+	return sum * cfg.Tick.Seconds()
+}
+
+func minValue(d Data) float64 {
+	if len(d) == 0 {
+		return 0
+	}
+	m := d[0]
+	for _, v := range d {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+func maxValue(d Data) float64 {
+	if len(d) == 0 {
+		return 0
+	}
+	m := d[0]
+	for _, v := range d {
+		m = math.Max(m, v)
+	}
+	return m
+}
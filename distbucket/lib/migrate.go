@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the version of the input schema. It is incremented
+// whenever settings are renamed or their defaults change, with a corresponding
+// entry in migrations.
+//
+//   - version 1: the distbucket-v21-06-03 schema; uses queued_time_scale and
+//     hardcodes the initial refill amount and the backlog factor.
+//   - version 2: queued_time_scale renamed to backlog_time_scale; added
+//     initial_refill_amount and backlog_factor_log_10.
+//
+// Inputs should always specify the version (the serializers and the workload
+// catalog do); see unversionedVersion for inputs that don't.
+const CurrentVersion = 2
+
+// unversionedVersion is the version assumed for an input that doesn't specify
+// one (unless it uses settings that only exist in older versions). It is the
+// version in which the version setting was added; it must not change when
+// CurrentVersion is incremented, since inputs without a version predate it.
+const unversionedVersion = 2
+
+// migrations[v] upgrades an input from version v to version v+1. The present
+// map contains the config keys that are set in the input YAML; these are used
+// to pin the defaults implied by the older version.
+var migrations = map[int]func(in *Input, present map[string]bool, errs *InputErrors){
+	1: func(in *Input, present map[string]bool, errs *InputErrors) {
+		cfg := &in.Config
+		if present["queued_time_scale"] {
+			errs.Warningf("config.queued_time_scale", "deprecated; use backlog_time_scale instead")
+			if !present["backlog_time_scale"] {
+				cfg.BacklogTimeScale = cfg.legacy.QueuedTimeScale
+			}
+		}
+		if present["queued_time_scale_secs"] {
+			errs.Warningf("config.queued_time_scale_secs", "deprecated; use backlog_time_scale_secs instead")
+			if !present["backlog_time_scale_secs"] {
+				cfg.BacklogTimeScale = time.Duration(cfg.legacy.QueuedTimeScaleSecs * float64(time.Second))
+			}
+		}
+		// Version 1 hardcoded these values.
+		if !present["initial_refill_amount"] {
+			cfg.InitialRefillAmount = 1000
+		}
+		if !present["backlog_factor_log_10"] {
+			cfg.BacklogFactorLog10 = -2
+		}
+	},
+}
+
+// legacySettings contains Config settings that only exist in older versions;
+// they are accepted during parsing and converted by the migrations.
+type legacySettings struct {
+	QueuedTimeScale     time.Duration `yaml:"queued_time_scale"`
+	QueuedTimeScaleSecs float64       `yaml:"queued_time_scale_secs"`
+}
+
+// legacyKey is a key in legacySettings.
+type legacyKey struct {
+	key string
+	// version is the last version in which the key was valid.
+	version int
+	// replacement is the key that replaces it in later versions.
+	replacement string
+}
+
+var legacyKeys = []legacyKey{
+	{key: "queued_time_scale", version: 1, replacement: "backlog_time_scale"},
+	{key: "queued_time_scale_secs", version: 1, replacement: "backlog_time_scale_secs"},
+}
+
+// findLegacyKey returns the legacyKeys entry for a key, if it is a legacy key.
+func findLegacyKey(key string) (legacyKey, bool) {
+	for _, l := range legacyKeys {
+		if l.key == key {
+			return l, true
+		}
+	}
+	return legacyKey{}, false
+}
+
+// addedKeys lists the config keys that were added after version 1, along with
+// the version in which they were added. Using them in an input with an older
+// version is allowed (the migrations preserve their values) but is likely a
+// mistake.
+var addedKeys = []struct {
+	key     string
+	version int
+}{
+	{key: "backlog_time_scale", version: 2},
+	{key: "backlog_time_scale_secs", version: 2},
+	{key: "initial_refill_amount", version: 2},
+	{key: "backlog_factor_log_10", version: 2},
+}
+
+// UnmarshalYAML is part of the yaml.Unmarshaler interface. It accepts the
+// settings from older versions in addition to the current ones.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// plainConfig has no methods, so unmarshaling it doesn't recurse.
+	type plainConfig Config
+	var v struct {
+		plainConfig    `yaml:",inline"`
+		legacySettings `yaml:",inline"`
+	}
+	v.plainConfig = plainConfig(*c)
+	if err := unmarshal(&v); err != nil {
+		// Refer to Config rather than the anonymous struct in the messages.
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for i := range typeErr.Errors {
+				typeErr.Errors[i] = strings.Replace(
+					typeErr.Errors[i], fmt.Sprintf("%T", v), fmt.Sprintf("%T", *c), 1,
+				)
+			}
+		}
+		return err
+	}
+	*c = Config(v.plainConfig)
+	c.legacy = v.legacySettings
+	return nil
+}
+
+// migrateInput upgrades an input to the current version; inputYAML is the
+// source of the input. Returns warnings for any deprecated settings (and
+// errors if the version is invalid).
+func migrateInput(in *Input, inputYAML string) InputErrors {
+	var errs InputErrors
+	// Find out which config keys are set.
+	var keys struct {
+		Version *int
+		Config  map[string]interface{}
+	}
+	if err := yaml.Unmarshal([]byte(inputYAML), &keys); err != nil {
+		// We only get here after a successful (strict) parse.
+		errs.Errorf("", "%v", err)
+		return errs
+	}
+	present := make(map[string]bool)
+	for k := range keys.Config {
+		present[k] = true
+	}
+
+	version := unversionedVersion
+	if keys.Version != nil {
+		version = *keys.Version
+	} else {
+		// An input without a version is assumed to be current, unless it uses
+		// legacy settings.
+		for _, l := range legacyKeys {
+			if present[l.key] && l.version < version {
+				version = l.version
+			}
+		}
+		if version != unversionedVersion {
+			errs.Warningf(
+				"", "version not specified; assuming version %d because of the legacy settings (add \"version: %d\" to the input)",
+				version, version,
+			)
+		}
+	}
+	if version < 1 || version > CurrentVersion {
+		errs.Errorf("version", "unsupported version %d (current version is %d)", version, CurrentVersion)
+		return errs
+	}
+	for _, l := range legacyKeys {
+		if present[l.key] && version > l.version {
+			errs.Errorf("config."+l.key, "not supported in version %d", version)
+		}
+	}
+	for _, a := range addedKeys {
+		if present[a.key] && version < a.version {
+			errs.Warningf("config."+a.key, "added in version %d; the input has version %d", a.version, version)
+		}
+	}
+	if errs.HasErrors() {
+		return errs
+	}
+	if version < CurrentVersion {
+		errs.Warningf(
+			"version", "version %d is deprecated; the input was migrated to version %d",
+			version, CurrentVersion,
+		)
+	}
+	migrateVariants(in, version, &errs)
+	for ; version < CurrentVersion; version++ {
+		migrations[version](in, present, &errs)
+	}
+	in.Version = CurrentVersion
+	in.Config.legacy = legacySettings{}
+	return errs
+}
+
+// migrateVariants renames the legacy keys in the config overrides of the
+// variants (which use the same keys as the config), for an input with the
+// given version. Legacy keys that were not valid in that version are left for
+// variantConfigs to report.
+func migrateVariants(in *Input, version int, errs *InputErrors) {
+	for i := range in.Variants {
+		overrides := in.Variants[i].Config
+		path := fmt.Sprintf("variants[%d].config.", i)
+		for _, a := range addedKeys {
+			if _, ok := overrides[a.key]; ok && version < a.version {
+				errs.Warningf(path+a.key, "added in version %d; the input has version %d", a.version, version)
+			}
+		}
+		for _, l := range legacyKeys {
+			value, ok := overrides[l.key]
+			if !ok || version > l.version {
+				continue
+			}
+			errs.Warningf(path+l.key, "deprecated; use %s instead", l.replacement)
+			if _, ok := overrides[l.replacement]; !ok {
+				overrides[l.replacement] = value
+			}
+			delete(overrides, l.key)
+		}
+	}
+}
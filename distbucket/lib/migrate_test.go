@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateInputWarnings(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		// errors are the expected errors and warnings.
+		errors []string
+	}{
+		{
+			name:  "empty",
+			input: ``,
+		},
+		{
+			name: "no version",
+			input: `
+config:
+  backlog_time_scale: 5s
+`,
+		},
+		{
+			name: "no version, legacy key",
+			input: `
+config:
+  queued_time_scale: 5s
+`,
+			errors: []string{
+				`warning: version not specified; assuming version 1 because of the legacy settings (add "version: 1" to the input)`,
+				"version: warning: version 1 is deprecated; the input was migrated to version 2",
+				"line 3: config.queued_time_scale: warning: deprecated; use backlog_time_scale instead",
+			},
+		},
+		{
+			name: "version 1, added key",
+			input: `
+version: 1
+config:
+  initial_refill_amount: 500
+`,
+			errors: []string{
+				"line 4: config.initial_refill_amount: warning: added in version 2; the input has version 1",
+				"line 2: version: warning: version 1 is deprecated; the input was migrated to version 2",
+			},
+		},
+		{
+			name: "version 2, legacy key",
+			input: `
+version: 2
+config:
+  queued_time_scale: 5s
+`,
+			errors: []string{"line 4: config.queued_time_scale: not supported in version 2"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in, errs := parseInput(tc.input)
+			errs.locate(inputPositions(tc.input, FormatYAML))
+			var actual []string
+			for _, e := range errs {
+				actual = append(actual, e.Error())
+			}
+			if strings.Join(actual, "\n") != strings.Join(tc.errors, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tc.errors, "\n"), strings.Join(actual, "\n"))
+			}
+			if !errs.HasErrors() && in.Version != CurrentVersion {
+				t.Errorf("expected version %d, got %d", CurrentVersion, in.Version)
+			}
+		})
+	}
+}
+
+func TestMigrateVariants(t *testing.T) {
+	const nodes = `
+config:
+  timeframe: 10s
+nodes:
+- terms:
+  - type: constant
+    value: 10
+`
+	testCases := []struct {
+		name  string
+		input string
+		// errors are the expected errors and warnings, as reported by Process.
+		errors []string
+	}{
+		{
+			name: "version 1",
+			input: "version: 1" + nodes + `
+variants:
+- config:
+    queued_time_scale: 5s
+`,
+			errors: []string{
+				"line 1: version: warning: version 1 is deprecated; the input was migrated to version 2",
+				"line 11: variants[0].config.queued_time_scale: warning: deprecated; use backlog_time_scale instead",
+			},
+		},
+		{
+			name: "version 2",
+			input: "version: 2" + nodes + `
+variants:
+- config:
+    queued_time_scale_secs: 5
+`,
+			errors: []string{
+				"line 11: variants[0].config.queued_time_scale_secs: not supported in version 2; use backlog_time_scale_secs instead",
+			},
+		},
+		{
+			name: "unknown keys",
+			input: "version: 2" + nodes + `
+variants:
+- config:
+    foo: 1
+    rate_per_sec: abc
+`,
+			errors: []string{
+				"line 11: variants[0].config.foo: field foo not found in type lib.Config",
+				"line 12: variants[0].config.rate_per_sec: cannot unmarshal !!str `abc` into float64",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []string
+			for _, e := range ProcessFormat(tc.input, FormatYAML).Errors {
+				actual = append(actual, e.Error())
+			}
+			if strings.Join(actual, "\n") != strings.Join(tc.errors, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tc.errors, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// This file implements the protocol overhead statistics: the settings of the
+// distributed token bucket (like TargetRefillPeriod, MinRefillAmount and
+// RefillFraction) trade accuracy for fewer requests to the global bucket, and
+// these statistics show the cost side of that tradeoff. They are computed from
+// the requests to the global bucket (see EventLog), which are only available
+// for the distributed token bucket.
+
+// requestRateWindow is the window over which the request rate is averaged.
+const requestRateWindow = 10 * time.Second
+
+// overheadStat is a statistic of a set of requests to the global bucket.
+type overheadStat struct {
+	name    string
+	unit    string
+	compute func(cfg *Config, events EventLog) float64
+}
+
+var overheadStats = []overheadStat{
+	{
+		name: "global requests",
+		compute: func(cfg *Config, events EventLog) float64 {
+			return float64(len(events))
+		},
+	},
+	{
+		name: "request rate",
+		unit: "req/s",
+		compute: func(cfg *Config, events EventLog) float64 {
+			return float64(len(events)) / cfg.Timeframe.Seconds()
+		},
+	},
+	{
+		name: "max request rate",
+		unit: "req/s",
+		compute: func(cfg *Config, events EventLog) float64 {
+			return maxValue(requestRate(cfg, events))
+		},
+	},
+	grantedQuantile("p10", 0.1),
+	grantedQuantile("median", 0.5),
+	grantedQuantile("p90", 0.9),
+	grantedQuantile("max", 1),
+	deadlineQuantile("p10", 0.1),
+	deadlineQuantile("median", 0.5),
+	deadlineQuantile("p90", 0.9),
+	deadlineQuantile("max", 1),
+}
+
+func grantedQuantile(name string, q float64) overheadStat {
+	return overheadStat{
+		name: fmt.Sprintf("granted per request (%s)", name),
+		unit: "RU",
+		compute: func(cfg *Config, events EventLog) float64 {
+			return quantile(events.values(func(e *RefillEvent) float64 {
+				return e.Granted
+			}), q)
+		},
+	}
+}
+
+func deadlineQuantile(name string, q float64) overheadStat {
+	return overheadStat{
+		name: fmt.Sprintf("refill deadline (%s)", name),
+		unit: "s",
+		compute: func(cfg *Config, events EventLog) float64 {
+			return quantile(events.values(func(e *RefillEvent) float64 {
+				return float64(e.DeadlineTick-e.Tick) * cfg.Tick.Seconds()
+			}), q)
+		},
+	}
+}
+
+// values returns a value for each event.
+func (l EventLog) values(fn func(e *RefillEvent) float64) []float64 {
+	res := make([]float64, len(l))
+	for i := range l {
+		res[i] = fn(&l[i])
+	}
+	return res
+}
+
+// node returns the events for a node (with a 0-based index).
+func (l EventLog) node(nodeIdx int) EventLog {
+	var res EventLog
+	for _, e := range l {
+		if e.Node == nodeIdx+1 {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// quantile returns the q-quantile of the values (using the nearest rank), or 0
+// if there are no values. The values are sorted in place.
+func quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	i := int(math.Ceil(q*float64(len(values)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+// requestRate returns the rate of requests at each tick, averaged over the
+// preceding requestRateWindow (including any time before the start of the
+// timeframe, where there are no requests).
+func requestRate(cfg *Config, events EventLog) Data {
+	counts := ZeroData(cfg)
+	for _, e := range events {
+		counts[e.Tick]++
+	}
+	window := cfg.TickForTime(requestRateWindow)
+	if window < 1 {
+		window = 1
+	}
+	rate := ZeroData(cfg)
+	var sum float64
+	for t := range counts {
+		sum += counts[t]
+		if t >= window {
+			sum -= counts[t-window]
+		}
+		rate[t] = sum / (float64(window) * cfg.Tick.Seconds())
+	}
+	return rate
+}
+
+// overheadTable returns a table with the overhead statistics for the runs that
+// have the requests to the global bucket (or false if there are none).
+func overheadTable(names []string, runs []*run) (Table, bool) {
+	t := Table{Title: "Global bucket requests"}
+	var withEvents []*run
+	for i, r := range runs {
+		if r.events != nil {
+			t.Columns = append(t.Columns, names[i])
+			withEvents = append(withEvents, r)
+		}
+	}
+	if len(withEvents) == 0 {
+		return Table{}, false
+	}
+	for _, s := range overheadStats {
+		row := TableRow{Name: s.name, Unit: s.unit}
+		for _, r := range withEvents {
+			row.Values = append(row.Values, s.compute(r.cfg, *r.events))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, true
+}
+
+// nodeOverheadTable returns a table with the overhead statistics for all the
+// requests in the run and for the requests of each node.
+func nodeOverheadTable(r *run) Table {
+	t := Table{
+		Title:   "Global bucket requests per node",
+		Columns: []string{"all"},
+	}
+	events := []EventLog{*r.events}
+	for i := range r.requested {
+		t.Columns = append(t.Columns, fmt.Sprintf("n%d", i+1))
+		events = append(events, r.events.node(i))
+	}
+	for _, s := range overheadStats {
+		row := TableRow{Name: s.name, Unit: s.unit}
+		for _, e := range events {
+			row.Values = append(row.Values, s.compute(r.cfg, e))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// requestRateChart returns a chart with the rate of requests to the global
+// bucket for the runs that have the requests. If perNode is set, the chart
+// also shows the rate for each node.
+func requestRateChart(names []string, runs []*run, perNode bool) Chart {
+	c := Chart{
+		Title: fmt.Sprintf("Global bucket request rate (over %s)", requestRateWindow),
+		Units: []Unit{{Name: "req/s"}},
+	}
+	for i, r := range runs {
+		if r.events == nil {
+			continue
+		}
+		c.Series = append(c.Series, Series{
+			Name:  names[i],
+			Unit:  "req/s",
+			Width: 1,
+			Data:  requestRate(r.cfg, *r.events),
+		})
+		if perNode {
+			for n := range r.requested {
+				c.Series = append(c.Series, Series{
+					Name:  fmt.Sprintf("n%d", n+1),
+					Unit:  "req/s",
+					Width: 0.5,
+					Data:  requestRate(r.cfg, r.events.node(n)),
+				})
+			}
+		}
+	}
+	return c
+}
+
+// overheadScatter returns a scatter plot of the accuracy (RMS error vs ideal)
+// and the number of requests to the global bucket, with a point for each run
+// that has the requests (or false if there are fewer than two such runs).
+func overheadScatter(names []string, runs []*run) (Scatter, bool) {
+	s := Scatter{
+		Title:  "Accuracy vs overhead",
+		XLabel: "global requests",
+		YLabel: "RMS error vs ideal (RU/s)",
+	}
+	for i, r := range runs {
+		if r.events != nil {
+			s.Points = append(s.Points, ScatterPoint{
+				Name: names[i],
+				X:    float64(len(*r.events)),
+				Y:    r.rmsError(),
+			})
+		}
+	}
+	return s, len(s.Points) >= 2
+}
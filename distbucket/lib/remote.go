@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file exposes the global and local buckets of the distributed token
+// bucket separately, so that they can run outside of a Simulation (e.g. with
+// the global bucket behind an RPC server, see package emulate). Unlike in a
+// Simulation, the responses to refill requests can arrive at a later tick.
+
+// RefillRequest is a request from a local bucket to the global bucket.
+type RefillRequest struct {
+	// Node is the 1-based index of the node.
+	Node int
+	// PrevShares are the shares sent with the previous request from the node.
+	PrevShares float64
+	Shares     float64
+	Amount     float64
+}
+
+// RefillResponse is the response of the global bucket to a RefillRequest.
+type RefillResponse struct {
+	// Tick is the global bucket tick at which the request was processed.
+	Tick         int
+	Granted      float64
+	DeadlineTick int
+}
+
+// GlobalBucket is the global bucket of the distributed token bucket. It is not
+// safe for concurrent use.
+type GlobalBucket struct {
+	cfg    Config
+	gb     globalBucket
+	tokens Data
+	// now is the last simulated tick (-1 initially).
+	now int
+}
+
+// NewGlobalBucket creates a global bucket. The capacity model settings (like
+// GlobalServiceRate) are ignored: the requests are processed as they arrive.
+func NewGlobalBucket(cfg *Config) *GlobalBucket {
+	g := &GlobalBucket{
+		cfg:    *cfg,
+		tokens: ZeroData(cfg),
+		now:    -1,
+	}
+	g.gb.init(&g.cfg)
+	return g
+}
+
+// AdvanceTo simulates all the ticks up to and including the given tick.
+func (g *GlobalBucket) AdvanceTo(tick int) {
+	if tick >= g.cfg.NumTicks() {
+		tick = g.cfg.NumTicks() - 1
+	}
+	for g.now < tick {
+		g.now++
+		g.gb.tick(&g.cfg, g.now)
+		g.tokens[g.now] = g.gb.currTokens
+	}
+}
+
+// Request processes a refill request at the current tick (the last tick
+// passed to AdvanceTo).
+func (g *GlobalBucket) Request(req RefillRequest) (RefillResponse, error) {
+	for _, v := range []float64{req.PrevShares, req.Shares, req.Amount} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return RefillResponse{}, fmt.Errorf("invalid request from node %d: %+v", req.Node, req)
+		}
+	}
+	now := g.now
+	if now < 0 {
+		now = 0
+	}
+	granted, deadlineTick := g.gb.request(&g.cfg, now, req.PrevShares, req.Shares, req.Amount)
+	return RefillResponse{Tick: now, Granted: granted, DeadlineTick: deadlineTick}, nil
+}
+
+// Tokens returns the amount of tokens in the global bucket at each tick. The
+// values for ticks that were not simulated yet are zero.
+func (g *GlobalBucket) Tokens() Data {
+	return g.tokens.Copy(&g.cfg)
+}
+
+// LocalBucket is the local bucket of a node. It is not safe for concurrent use.
+type LocalBucket struct {
+	cfg Config
+	l   localBucket
+}
+
+// NewLocalBucket creates the local bucket for a node with the given requested
+// rate; nodeIdx is the 0-based index of the node.
+func NewLocalBucket(cfg *Config, requested Data, nodeIdx int) *LocalBucket {
+	l := &LocalBucket{cfg: *cfg}
+	cfg = &l.cfg
+	// Convert from rate to absolute amount.
+	requested = requested.Copy(cfg)
+	requested.Scale(cfg.Tick.Seconds())
+	l.l.init(cfg, requested, nodeIdx)
+	return l
+}
+
+// RefillRequest returns the request that the node should send to the global
+// bucket at the given tick, or false if the bucket doesn't need a refill.
+func (l *LocalBucket) RefillRequest(tick int) (RefillRequest, bool) {
+	amount, shares, ok := l.l.refillRequest(&l.cfg, tick)
+	if !ok {
+		return RefillRequest{}, false
+	}
+	return RefillRequest{
+		Node:       l.l.nodeIdx + 1,
+		PrevShares: l.l.lastShares,
+		Shares:     shares,
+		Amount:     amount,
+	}, true
+}
+
+// Refill applies the response to a request, received at the given tick. The
+// tokens are distributed until the deadline, or right away if the deadline
+// already passed.
+func (l *LocalBucket) Refill(tick int, req RefillRequest, resp RefillResponse) {
+	deadlineTick := resp.DeadlineTick
+	if deadlineTick < tick {
+		deadlineTick = tick
+	}
+	l.l.refill(tick, req.Shares, resp.Granted, deadlineTick)
+}
+
+// Tick grants as much of the requested amount as possible at the given tick.
+// Ticks must be processed in order.
+func (l *LocalBucket) Tick(tick int) {
+	l.l.consume(&l.cfg, tick)
+}
+
+// Granted returns the granted rate at each tick.
+func (l *LocalBucket) Granted() Data {
+	granted := l.l.granted.Copy(&l.cfg)
+	// Convert from absolute amount to rate.
+	granted.Scale(1.0 / l.cfg.Tick.Seconds())
+	return granted
+}
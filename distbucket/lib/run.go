@@ -0,0 +1,444 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Result contains the results of simulating an input.
+type Result struct {
+	TimeAxis []float64
+	// Requested is the requested rate for each node (including the instances
+	// of each group).
+	Requested PerNodeData
+
+	// Runs contains the results of each algorithm: the distributed and the
+	// ideal token bucket or, if the input has variants, one for each variant.
+	Runs []RunResult
+
+	// Events contains the requests to the global bucket made by the
+	// distributed token bucket, if enabled in the input (see OutputSettings).
+	Events EventLog
+
+	// Charts, Scatters and Tables are the same as in Output.
+	Charts   []Chart
+	Scatters []Scatter
+	Tables   []Table
+
+	// Warnings contains any problems with the input that are not errors.
+	Warnings InputErrors
+}
+
+// RunResult contains the results of running an algorithm.
+type RunResult struct {
+	Name      string
+	Algorithm string
+	Config    Config
+
+	// Granted is the granted rate for each node.
+	Granted PerNodeData
+	// Tokens is the amount of tokens in the (global) bucket at each tick.
+	Tokens Data
+
+	// Metrics contains the values in the metrics table (and, for the
+	// distributed token bucket, in the global bucket requests table), by name.
+	Metrics map[string]float64
+}
+
+// Output returns the result in the same form as Process.
+func (r *Result) Output() Output {
+	return Output{
+		TimeAxis: r.TimeAxis,
+		Charts:   r.Charts,
+		Scatters: r.Scatters,
+		Tables:   r.Tables,
+		Events:   r.Events,
+		Errors:   r.Warnings,
+	}
+}
+
+// Run simulates the input. Unlike Process, the durations in the config are
+// used as they are (the corresponding seconds fields are ignored). Problems
+// with the input are returned as InputErrors.
+func Run(in Input) (*Result, error) {
+	return RunWithAlgorithms(in, nil)
+}
+
+// RunWithAlgorithms is like Run, but the variants can also use the given
+// external algorithms (by name). If one of them fails, the error is returned
+// as an InputError for the variant's algorithm.
+func RunWithAlgorithms(in Input, external map[string]ExternalAlgorithm) (*Result, error) {
+	for name := range external {
+		if Algorithms[name] != nil {
+			return nil, fmt.Errorf("external algorithm '%s' has the name of a built-in algorithm", name)
+		}
+	}
+	res, errs := in.run(context.Background(), external)
+	if errs.HasErrors() {
+		return nil, errs.Filter(SeverityError)
+	}
+	res.Warnings = errs
+	return res, nil
+}
+
+// Validate checks the input without simulating it: the config, the output
+// settings, the node functions and the variants. The paths of the returned
+// errors are relative to the input.
+func (in *Input) Validate() InputErrors {
+	errs := in.Config.Validate().withPrefix("config")
+	errs = append(errs, in.Output.validate(in).withPrefix("output")...)
+	if errs.HasErrors() {
+		return errs
+	}
+	if _, _, err := in.requested(); err != nil {
+		errs = append(errs, toInputErrors(err)...)
+	}
+	if len(in.Variants) > 0 {
+		_, _, variantErrs := in.variantConfigs(nil /* external */)
+		errs = append(errs, variantErrs...)
+	}
+	return errs
+}
+
+// run simulates the input; the simulations of the distributed token bucket
+// stop once the context is done.
+func (in *Input) run(
+	ctx context.Context, external map[string]ExternalAlgorithm,
+) (res *Result, errs InputErrors) {
+	// The simulation code panics when one of its invariants is violated (see
+	// throw); that is not a problem with the input but we still want to report
+	// it. Any other panic is a bug and is not recovered.
+	defer func() {
+		if obj := recover(); obj != nil {
+			ie, ok := obj.(internalError)
+			if !ok {
+				panic(obj)
+			}
+			res = nil
+			errs = append(errs, InputError{
+				Severity: SeverityError,
+				Message:  ie.Error(),
+			})
+		}
+	}()
+
+	cfg := &in.Config
+	errs = append(errs, cfg.Validate().withPrefix("config")...)
+	errs = append(errs, in.Output.validate(in).withPrefix("output")...)
+	if errs.HasErrors() {
+		return nil, errs
+	}
+
+	requested, breakdown, err := in.requested()
+	if err != nil {
+		return nil, append(errs, toInputErrors(err)...)
+	}
+	aggregateRequested := requested.Aggregate(cfg)
+
+	var graphMax float64
+	for _, v := range aggregateRequested {
+		graphMax = math.Max(graphMax, v)
+	}
+	//for _, v := range aggregateDist {
+	//	graphMax = math.Max(graphMax, v)
+	//}
+	//for _, v := range aggregateIdeal {
+	//	graphMax = math.Max(graphMax, v)
+	//}
+
+	nodeSeries := make([]Series, len(requested))
+	for i := range nodeSeries {
+		nodeSeries[i] = Series{
+			Name:  fmt.Sprintf("n%d", i+1),
+			Unit:  "RU/s",
+			Width: 1,
+			Data:  requested[i],
+		}
+	}
+
+	res = &Result{
+		TimeAxis:  cfg.TimeAxis(),
+		Requested: requested,
+	}
+
+	res.Charts = append(res.Charts, Chart{
+		Title: "Requested",
+		Units: []Unit{
+			{
+				Name:       "RU/s",
+				FixedRange: []float64{0, graphMax},
+			},
+		},
+		Series: append(nodeSeries, Series{
+			Name:  "aggregate",
+			Unit:  "RU/s",
+			Width: 2,
+			Data:  aggregateRequested,
+		}),
+	})
+	if breakdown != nil {
+		res.Charts = append(res.Charts, breakdown.chart(cfg))
+	}
+
+	if len(in.Variants) > 0 {
+		variants, configs, variantErrs := in.variantConfigs(external)
+		errs = append(errs, variantErrs...)
+		if errs.HasErrors() {
+			return nil, errs
+		}
+		names := make([]string, len(variants))
+		runs := make([]*run, len(variants))
+		for i := range variants {
+			names[i] = variants[i].Name
+			alg := variants[i].Algorithm
+			switch {
+			case alg == "distributed":
+				sim := NewSimulation(&configs[i], requested)
+				sim.SetContext(ctx)
+				var err error
+				if runs[i], err = makeDistRun(&configs[i], requested, sim); err != nil {
+					errs.Errorf("", "%v", err)
+					return nil, errs
+				}
+			case external[alg] != nil:
+				var err error
+				if runs[i], err = makeExternalRun(&configs[i], requested, external[alg]); err != nil {
+					errs.Errorf(fmt.Sprintf("variants[%d].algorithm", i), "%s failed: %v", alg, err)
+					return nil, errs
+				}
+			default:
+				runs[i] = makeRun(&configs[i], requested, Algorithms[alg])
+			}
+			res.addRun(names[i], variants[i].Algorithm, runs[i])
+		}
+		charts, table := compareCharts(cfg, names, runs, requested)
+		res.Charts = append(res.Charts, charts...)
+		res.Tables = append(res.Tables, table)
+		if t, ok := overheadTable(names, runs); ok {
+			res.Charts = append(res.Charts, requestRateChart(names, runs, false /* perNode */))
+			res.Tables = append(res.Tables, t)
+		}
+		if t, ok := capacityTable(names, runs); ok {
+			res.Charts = append(res.Charts, capacityChart(names, runs))
+			res.Tables = append(res.Tables, t)
+		}
+		if s, ok := overheadScatter(names, runs); ok {
+			res.Scatters = append(res.Scatters, s)
+		}
+		return res, errs
+	}
+
+	sim := NewSimulation(cfg, requested)
+	if err := sim.RecordState(in.Output.Charts...); err != nil {
+		// The charts were validated above, so this doesn't happen.
+		errs.Errorf("output.charts", "%v", err)
+		return nil, errs
+	}
+	sim.SetContext(ctx)
+	dist, err := makeDistRun(cfg, requested, sim)
+	if err != nil {
+		errs.Errorf("", "%v", err)
+		return nil, errs
+	}
+	if in.Output.EventLog {
+		res.Events = *dist.events
+	}
+	stateCharts := sim.StateCharts()
+	grantedDist, tokensDist := dist.granted, dist.tokens
+	aggregateDist := grantedDist.Aggregate(cfg)
+	res.addRun("distributed", "distributed", dist)
+
+	ideal := makeRun(cfg, requested, TokenBucket)
+	grantedIdeal, tokensIdeal := ideal.granted, ideal.tokens
+	aggregateIdeal := grantedIdeal.Aggregate(cfg)
+	res.addRun("ideal", "ideal", ideal)
+	nodeSeries = make([]Series, len(requested))
+	for i := range nodeSeries {
+		g := grantedDist[i]
+		if cfg.Smoothing {
+			g = g.Smooth(cfg, 0.1)
+		}
+		nodeSeries[i] = Series{
+			Name:  fmt.Sprintf("n%d", i+1),
+			Unit:  "RU/s",
+			Width: 1,
+			Data:  g,
+		}
+	}
+
+	res.Charts = append(res.Charts, Chart{
+		Title:   "Granted (distributed token bucket)",
+		Markers: res.Events.Markers(),
+		Units: []Unit{
+			{
+				Name:       "RU/s",
+				FixedRange: []float64{0, graphMax},
+			},
+			{
+				Name: "RU",
+			},
+		},
+		Series: append(nodeSeries,
+			Series{
+				Name:  "aggregate",
+				Unit:  "RU/s",
+				Width: 2.5,
+				Data:  aggregateDist,
+			},
+			Series{
+				Name:  "global tokens",
+				Unit:  "RU",
+				Width: 0.5,
+				Data:  tokensDist,
+			},
+		),
+	})
+
+	nodeSeries = make([]Series, len(requested))
+	for i := range nodeSeries {
+		g := grantedIdeal[i]
+		if cfg.Smoothing {
+			g = g.Smooth(cfg, 0.1)
+		}
+		nodeSeries[i] = Series{
+			Name:  fmt.Sprintf("n%d", i+1),
+			Unit:  "RU/s",
+			Width: 1,
+			Data:  g,
+		}
+	}
+
+	res.Charts = append(res.Charts, Chart{
+		Title: "Granted (ideal token bucket)",
+		Units: []Unit{
+			{
+				Name:       "RU/s",
+				FixedRange: []float64{0, graphMax},
+			},
+			{
+				Name: "RU",
+			},
+		},
+		Series: append(nodeSeries,
+			Series{
+				Name:  "aggregate",
+				Unit:  "RU/s",
+				Width: 2.5,
+				Data:  aggregateIdeal,
+			},
+			Series{
+				Name:  "tokens",
+				Unit:  "RU",
+				Width: 0.5,
+				Data:  tokensIdeal,
+			},
+		),
+	})
+
+	// Generate total granted graphs.
+	totalDist := aggregateDist.Cumulative(cfg)
+	totalIdeal := aggregateIdeal.Cumulative(cfg)
+	res.Charts = append(res.Charts, Chart{
+		Title: "Total granted (vs ideal)",
+		Units: []Unit{
+			{
+				Name: "RU",
+			},
+		},
+		Series: []Series{
+			{
+				Name:  "distributed",
+				Unit:  "RU",
+				Width: 1,
+				Data:  totalDist,
+			},
+			{
+				Name:  "ideal",
+				Unit:  "RU",
+				Width: 1,
+				Data:  totalIdeal,
+			},
+		},
+	})
+
+	if cfg.estimationErrors() {
+		res.Charts = append(res.Charts, Chart{
+			Title: "Actual consumption (with estimation errors)",
+			Units: []Unit{
+				{
+					Name:       "RU/s",
+					FixedRange: []float64{0, graphMax},
+				},
+			},
+			Series: []Series{
+				{
+					Name:  "distributed",
+					Unit:  "RU/s",
+					Width: 1,
+					Data:  ActualConsumption(cfg, grantedDist).Aggregate(cfg),
+				},
+				{
+					Name:  "ideal",
+					Unit:  "RU/s",
+					Width: 1,
+					Data:  ActualConsumption(cfg, grantedIdeal).Aggregate(cfg),
+				},
+			},
+		})
+	}
+
+	if cfg.Budget > 0 {
+		res.Charts = append(res.Charts, budgetChart(
+			[]string{"distributed", "ideal"}, []*run{dist, ideal},
+		))
+	}
+
+	res.Charts = append(res.Charts, requestRateChart(
+		[]string{"all"}, []*run{dist}, true, /* perNode */
+	))
+
+	if dist.server != nil {
+		res.Charts = append(res.Charts, capacityChart([]string{"distributed"}, []*run{dist}))
+	}
+
+	res.Charts = append(res.Charts, stateCharts...)
+
+	res.Tables = append(res.Tables, metricsTable(
+		[]string{"distributed", "ideal"}, []*run{dist, ideal}, false, /* withDeltas */
+	))
+	res.Tables = append(res.Tables, nodeOverheadTable(dist))
+	if t, ok := capacityTable([]string{"distributed"}, []*run{dist}); ok {
+		res.Tables = append(res.Tables, t)
+	}
+
+	return res, errs
+}
+
+// addRun adds the results of a run.
+func (res *Result) addRun(name, algorithm string, r *run) {
+	rr := RunResult{
+		Name:      name,
+		Algorithm: algorithm,
+		Config:    *r.cfg,
+		Granted:   r.granted,
+		Tokens:    r.tokens,
+		Metrics:   make(map[string]float64, len(metrics)),
+	}
+	for _, m := range metrics {
+		if m.enabled == nil || m.enabled(r.cfg) {
+			rr.Metrics[m.name] = m.compute(r)
+		}
+	}
+	if r.events != nil {
+		for _, s := range overheadStats {
+			rr.Metrics[s.name] = s.compute(r.cfg, *r.events)
+		}
+	}
+	if r.server != nil {
+		for _, s := range serverStatList {
+			rr.Metrics[s.name] = s.compute(r.server)
+		}
+	}
+	res.Runs = append(res.Runs, rr)
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProcessChartErrors(t *testing.T) {
+	input := `
+config:
+  timeframe: 10s
+nodes:
+- terms:
+  - type: constant
+    value: 10
+output:
+  charts:
+  - ewma
+  - foo
+  - ewma
+`
+	var actual []string
+	for _, e := range InputErrors(ProcessFormat(input, FormatYAML).Errors).Filter(SeverityError) {
+		actual = append(actual, e.Error())
+	}
+	expected := []string{
+		"line 11: output.charts[1]: unknown chart 'foo' (must be one of: " + stateChartKeys() + ")",
+		"line 12: output.charts[2]: duplicate chart 'ewma'",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestProcessContext(t *testing.T) {
+	input := `
+config:
+  timeframe: 10s
+nodes:
+- terms:
+  - type: constant
+    value: 10
+`
+	ctx, cancel := context.WithCancel(context.Background())
+	if out := ProcessContext(ctx, input, FormatYAML); out.Error != "" {
+		t.Fatal(out.Error)
+	}
+	cancel()
+	out := ProcessContext(ctx, input, FormatYAML)
+	if expected := "simulation stopped at 0s: context canceled"; out.Error != expected {
+		t.Errorf("expected error '%s', got '%s'", expected, out.Error)
+	}
+}
@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Simulation is a step-by-step run of the distributed token bucket
+// (DistTokenBucket3), which allows inspecting the state of the global and
+// local buckets at any tick.
+type Simulation struct {
+	cfg          Config
+	global       globalBucket
+	local        []localBucket
+	globalTokens Data
+	// state contains the recorded state charts (see RecordState).
+	state []stateTrace
+
+	// now is the next tick to be simulated.
+	now int
+	// ctx, if set, stops the simulation once it is done (see SetContext).
+	ctx context.Context
+}
+
+// NewSimulation sets up a simulation for the given requested rates.
+func NewSimulation(cfg *Config, requested PerNodeData) *Simulation {
+	s := &Simulation{
+		cfg:          *cfg,
+		globalTokens: ZeroData(cfg),
+	}
+	cfg = &s.cfg
+
+	// Make copies of requested, since we are going to modify the data.
+	requested = requested.Copy(cfg)
+	// Convert from rate to absolute amount.
+	for i := range requested {
+		requested[i].Scale(cfg.Tick.Seconds())
+	}
+
+	s.global.init(cfg)
+	s.local = make([]localBucket, len(requested))
+	for i := range s.local {
+		s.local[i].init(cfg, requested[i], i)
+	}
+	return s
+}
+
+// NewSimulationFromYAML sets up a simulation for an input (in any of the
+// formats accepted by Process); any variants are ignored.
+func NewSimulationFromYAML(inputYAML string) (*Simulation, error) {
+	input, err := ParseInput(inputYAML)
+	if err != nil {
+		return nil, err
+	}
+	if errs := input.Config.Validate().withPrefix("config"); errs.HasErrors() {
+		errs.locate(inputPositions(inputYAML, FormatAuto))
+		return nil, errs.Filter(SeverityError)
+	}
+	requested, err := input.Requested()
+	if err != nil {
+		errs := toInputErrors(err)
+		errs.locate(inputPositions(inputYAML, FormatAuto))
+		return nil, errs
+	}
+	return NewSimulation(&input.Config, requested), nil
+}
+
+// RecordEvents enables recording of all the requests to the global bucket (see
+// Events). Must be called before the first Step.
+func (s *Simulation) RecordEvents() {
+	s.global.events = &EventLog{}
+}
+
+// Events returns the requests to the global bucket so far, if RecordEvents was
+// called.
+func (s *Simulation) Events() EventLog {
+	if s.global.events == nil {
+		return nil
+	}
+	return *s.global.events
+}
+
+// Config returns the configuration of the simulation.
+func (s *Simulation) Config() Config {
+	return s.cfg
+}
+
+// Now returns the number of ticks simulated so far.
+func (s *Simulation) Now() int {
+	return s.now
+}
+
+// Done returns true if all the ticks have been simulated.
+func (s *Simulation) Done() bool {
+	return s.now >= s.cfg.NumTicks()
+}
+
+// SetContext makes Step stop the simulation once the context is done; see
+// Err.
+func (s *Simulation) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// Err returns the error of the context if the simulation was stopped before
+// all the ticks were simulated (see SetContext).
+func (s *Simulation) Err() error {
+	if s.ctx == nil || s.Done() {
+		return nil
+	}
+	return s.ctx.Err()
+}
+
+// Step simulates the next tick. Returns false if there are no more ticks, or
+// if the simulation was stopped (see SetContext).
+func (s *Simulation) Step() bool {
+	if s.Done() || (s.ctx != nil && s.ctx.Err() != nil) {
+		return false
+	}
+	cfg := &s.cfg
+	s.global.tick(cfg, s.now)
+	s.globalTokens[s.now] = s.global.currTokens
+
+	if server := s.global.server; server == nil {
+		for n := range s.local {
+			s.local[n].tick(cfg, &s.global, s.now)
+		}
+	} else {
+		for n := range s.local {
+			s.local[n].maintain(cfg, &s.global, s.now)
+		}
+		server.process(cfg, &s.global, s.now)
+		for _, resp := range server.deliver(cfg, s.now) {
+			s.local[resp.nodeIdx].receive(s.now, resp)
+		}
+		for n := range s.local {
+			s.local[n].consume(cfg, s.now)
+		}
+	}
+	s.recordState()
+	s.now++
+	return true
+}
+
+// RunUntil simulates all the ticks before the given time (in seconds).
+func (s *Simulation) RunUntil(t float64) {
+	tick := s.cfg.TickForTime(time.Duration(t * float64(time.Second)))
+	for s.now < tick && s.Step() {
+	}
+}
+
+// Results returns the granted rate for each node and the global tokens at each
+// tick. The values for ticks that were not simulated yet are zero.
+func (s *Simulation) Results() (granted PerNodeData, globalTokens Data) {
+	cfg := &s.cfg
+	granted = MakePerNodeData(cfg, len(s.local))
+	for i := range granted {
+		granted[i] = s.local[i].granted.Copy(cfg)
+		// Convert from absolute amount to rate.
+		granted[i].Scale(1.0 / cfg.Tick.Seconds())
+	}
+	return granted, s.globalTokens.Copy(cfg)
+}
+
+// TickResults returns the requested and granted rate for each node at a tick
+// that was already simulated, along with the global tokens.
+func (s *Simulation) TickResults(
+	tick int,
+) (requested, granted []float64, globalTokens float64, err error) {
+	if tick < 0 || tick >= s.now {
+		return nil, nil, 0, fmt.Errorf("tick %d was not simulated (%d ticks simulated so far)", tick, s.now)
+	}
+	requested = make([]float64, len(s.local))
+	granted = make([]float64, len(s.local))
+	for i := range s.local {
+		// Convert from absolute amount to rate.
+		requested[i] = s.local[i].requested[tick] / s.cfg.Tick.Seconds()
+		granted[i] = s.local[i].granted[tick] / s.cfg.Tick.Seconds()
+	}
+	return requested, granted, s.globalTokens[tick], nil
+}
+
+// Snapshot is the state of a Simulation after a number of ticks.
+type Snapshot struct {
+	// Tick is the number of ticks simulated so far; Time is the corresponding
+	// time, in seconds.
+	Tick int
+	Time float64
+
+	Global GlobalBucketState
+	Nodes  []LocalBucketState
+}
+
+// GlobalBucketState is the state of the global bucket.
+type GlobalBucketState struct {
+	Tokens    float64
+	SharesSum float64
+}
+
+// LocalBucketState is the state of a node's local bucket.
+type LocalBucketState struct {
+	Tokens float64
+	// RefillRatePerTick is the rate at which the tokens from the last refill
+	// are added to the bucket, until DeadlineTick.
+	RefillRatePerTick float64
+	DeadlineTick      int
+	LastRefillTick    int
+	LastRefillAmount  float64
+	// ReqEWMA is the moving average of the requested amount per tick.
+	ReqEWMA float64
+	// Shares are the shares sent with the last global request.
+	Shares float64
+	// Backlog is the amount requested but not yet granted; WeightedBacklog is
+	// the backlog weighted exponentially by age (as used for the shares).
+	Backlog         float64
+	WeightedBacklog float64
+	// Granted is the total amount granted so far.
+	Granted float64
+}
+
+// Snapshot returns the current state of the simulation.
+func (s *Simulation) Snapshot() Snapshot {
+	snap := Snapshot{
+		Tick: s.now,
+		Time: s.cfg.TimeForTick(s.now).Seconds(),
+		Global: GlobalBucketState{
+			Tokens:    s.global.currTokens,
+			SharesSum: s.global.sharesSum,
+		},
+		Nodes: make([]LocalBucketState, len(s.local)),
+	}
+	for i := range s.local {
+		l := &s.local[i]
+		n := &snap.Nodes[i]
+		n.Tokens = l.currTokens
+		n.RefillRatePerTick = l.currRatePerTick
+		n.DeadlineTick = l.deadlineTick
+		n.LastRefillTick = l.lastRefillTick
+		n.LastRefillAmount = l.lastRefillAmount
+		n.ReqEWMA = l.reqEWMA
+		n.Shares = l.lastShares
+		// The backlog includes ticks up to the last simulated tick.
+		n.Backlog, n.WeightedBacklog = l.backlog(s.now - 1)
+		for _, v := range l.granted {
+			n.Granted += v
+		}
+	}
+	return snap
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// stateChart is an optional chart of the internal state of the distributed
+// token bucket, which can be enabled in the input (see OutputSettings.Charts).
+// Exactly one of node and global is set.
+type stateChart struct {
+	key   string
+	title string
+	unit  string
+
+	// node returns the value for a local bucket after a tick.
+	node func(cfg *Config, l *localBucket, now int) float64
+	// global returns the value for the global bucket after a tick.
+	global func(cfg *Config, gb *globalBucket) float64
+}
+
+var stateCharts = []stateChart{
+	{
+		key:   "local_tokens",
+		title: "Local tokens",
+		unit:  "RU",
+		node: func(cfg *Config, l *localBucket, now int) float64 {
+			return l.currTokens
+		},
+	},
+	{
+		key:   "refill_rate",
+		title: "Local refill rate",
+		unit:  "RU/s",
+		node: func(cfg *Config, l *localBucket, now int) float64 {
+			if l.deadlineTick <= now {
+				return 0
+			}
+			return l.currRatePerTick / cfg.Tick.Seconds()
+		},
+	},
+	{
+		key:   "ewma",
+		title: "Requested rate EWMA",
+		unit:  "RU/s",
+		node: func(cfg *Config, l *localBucket, now int) float64 {
+			return l.reqEWMA / cfg.Tick.Seconds()
+		},
+	},
+	{
+		key:   "shares",
+		title: "Shares",
+		unit:  "shares",
+		node: func(cfg *Config, l *localBucket, now int) float64 {
+			return l.lastShares
+		},
+	},
+	{
+		key:   "weighted_backlog",
+		title: "Weighted backlog",
+		unit:  "RU",
+		node: func(cfg *Config, l *localBucket, now int) float64 {
+			_, weighted := l.backlog(now)
+			return weighted
+		},
+	},
+	{
+		key:   "global_debt",
+		title: "Global debt",
+		unit:  "RU",
+		global: func(cfg *Config, gb *globalBucket) float64 {
+			return math.Max(0, -gb.currTokens)
+		},
+	},
+	{
+		key:   "shares_sum",
+		title: "Global shares sum",
+		unit:  "shares",
+		global: func(cfg *Config, gb *globalBucket) float64 {
+			return gb.sharesSum
+		},
+	},
+}
+
+func findStateChart(key string) *stateChart {
+	for i := range stateCharts {
+		if stateCharts[i].key == key {
+			return &stateCharts[i]
+		}
+	}
+	return nil
+}
+
+// stateChartKeys returns the keys of all the state charts, for error messages.
+func stateChartKeys() string {
+	keys := make([]string, len(stateCharts))
+	for i := range stateCharts {
+		keys[i] = stateCharts[i].key
+	}
+	return strings.Join(keys, ", ")
+}
+
+// stateTrace contains the recorded values for a state chart: one Data per node,
+// or a single Data for global quantities.
+type stateTrace struct {
+	chart *stateChart
+	data  PerNodeData
+}
+
+// RecordState enables recording of the given state charts (see
+// OutputSettings.Charts); the charts are returned by StateCharts. Must be
+// called before the first Step.
+func (s *Simulation) RecordState(keys ...string) error {
+	for _, key := range keys {
+		c := findStateChart(key)
+		if c == nil {
+			return fmt.Errorf("unknown chart '%s' (must be one of: %s)", key, stateChartKeys())
+		}
+		n := len(s.local)
+		if c.global != nil {
+			n = 1
+		}
+		s.state = append(s.state, stateTrace{chart: c, data: MakePerNodeData(&s.cfg, n)})
+	}
+	return nil
+}
+
+// recordState records the state after simulating the current tick.
+func (s *Simulation) recordState() {
+	cfg := &s.cfg
+	for _, t := range s.state {
+		if t.chart.global != nil {
+			t.data[0][s.now] = t.chart.global(cfg, &s.global)
+			continue
+		}
+		for i := range s.local {
+			t.data[i][s.now] = t.chart.node(cfg, &s.local[i], s.now)
+		}
+	}
+}
+
+// StateCharts returns the charts enabled with RecordState. The values for ticks
+// that were not simulated yet are zero.
+func (s *Simulation) StateCharts() []Chart {
+	charts := make([]Chart, len(s.state))
+	for i, t := range s.state {
+		series := make([]Series, len(t.data))
+		for j := range series {
+			name := fmt.Sprintf("n%d", j+1)
+			if t.chart.global != nil {
+				name = "global"
+			}
+			series[j] = Series{
+				Name:  name,
+				Unit:  t.chart.unit,
+				Width: 1,
+				Data:  t.data[j].Copy(&s.cfg),
+			}
+		}
+		charts[i] = Chart{
+			Title:  t.chart.title + " (distributed token bucket)",
+			Units:  []Unit{{Name: t.chart.unit}},
+			Series: series,
+		}
+	}
+	return charts
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// NodeGroup defines a number of similar nodes. Each instance gets the same
+// terms, with some per-instance variation; the random variations (and the
+// noise terms) are deterministic for a given seed.
+type NodeGroup struct {
+	Count int
+	// Templates and Terms are as in FuncDesc.
+	Templates []string   `yaml:",omitempty"`
+	Terms     []FuncTerm `yaml:",omitempty"`
+
+	// AmplitudeJitter is the maximum relative change to the magnitude of each
+	// instance's terms (value, delta, amplitude); e.g. 0.2 means that the
+	// magnitudes are scaled by a random factor between 0.8 and 1.2.
+	AmplitudeJitter float64 `yaml:"amplitude_jitter,omitempty"`
+	// PhaseJitter is the maximum phase offset (in seconds) of each instance's
+	// sine terms.
+	PhaseJitter float64 `yaml:"phase_jitter,omitempty"`
+	// Stagger is the delay (in seconds) between the starts of consecutive
+	// instances; terms that would start after the timeframe are dropped.
+	Stagger float64 `yaml:",omitempty"`
+	Seed    int64   `yaml:",omitempty"`
+}
+
+// maxGroupCount is the maximum number of instances in a group.
+const maxGroupCount = 10000
+
+// expandedNode is the function of a node after templates and groups are
+// expanded. termPaths contains the input path of each term, for errors.
+type expandedNode struct {
+	terms     []FuncTerm
+	termPaths []string
+}
+
+// addTemplates appends the terms of the given templates; path is the path of
+// the templates list.
+func (n *expandedNode) addTemplates(in *Input, path string, names []string, errs *InputErrors) {
+	for i, name := range names {
+		terms, ok := in.Templates[name]
+		if !ok {
+			errs.Errorf(fmt.Sprintf("%s[%d]", path, i), "unknown template '%s'", name)
+			continue
+		}
+		n.addTerms(fmt.Sprintf("templates.%s", name), terms)
+	}
+}
+
+func (n *expandedNode) addTerms(path string, terms []FuncTerm) {
+	for i, t := range terms {
+		n.terms = append(n.terms, t)
+		n.termPaths = append(n.termPaths, fmt.Sprintf("%s[%d]", path, i))
+	}
+}
+
+// NumNodes returns the number of nodes in the input, including the instances
+// of each group.
+func (in *Input) NumNodes() int {
+	n := len(in.Nodes)
+	for i := range in.Groups {
+		if c := in.Groups[i].Count; c > 0 {
+			n += c
+		}
+	}
+	return n
+}
+
+// expandNodes returns the functions of all the nodes: first those in Nodes,
+// followed by the instances of each group.
+func (in *Input) expandNodes() ([]expandedNode, InputErrors) {
+	var errs InputErrors
+	var nodes []expandedNode
+	for i := range in.Nodes {
+		path := fmt.Sprintf("nodes[%d]", i)
+		var n expandedNode
+		n.addTemplates(in, path+".templates", in.Nodes[i].Templates, &errs)
+		n.addTerms(path+".terms", in.Nodes[i].Terms)
+		nodes = append(nodes, n)
+	}
+
+	for g := range in.Groups {
+		group := &in.Groups[g]
+		path := fmt.Sprintf("groups[%d]", g)
+		if group.Count < 1 || group.Count > maxGroupCount {
+			errs.Errorf(path+".count", "invalid count %d (must be between 1 and %d)", group.Count, maxGroupCount)
+		}
+		if group.AmplitudeJitter < 0 || group.AmplitudeJitter > 1 {
+			errs.Errorf(path+".amplitude_jitter", "%v must be between 0 and 1", group.AmplitudeJitter)
+		}
+		if group.PhaseJitter < 0 {
+			errs.Errorf(path+".phase_jitter", "%v must be at least 0", group.PhaseJitter)
+		}
+		if group.Stagger < 0 {
+			errs.Errorf(path+".stagger", "%v must be at least 0", group.Stagger)
+		}
+		var base expandedNode
+		base.addTemplates(in, path+".templates", group.Templates, &errs)
+		base.addTerms(path+".terms", group.Terms)
+		if errs.HasErrors() {
+			continue
+		}
+		for i := 0; i < group.Count; i++ {
+			nodes = append(nodes, group.instance(&in.Config, base, i))
+		}
+	}
+	return nodes, errs
+}
+
+// instance returns the terms for the i-th instance of the group.
+func (group *NodeGroup) instance(cfg *Config, base expandedNode, i int) expandedNode {
+	r := rand.New(rand.NewSource(group.Seed*1000003 + int64(i)))
+	scale := 1 + group.AmplitudeJitter*(2*r.Float64()-1)
+	phase := group.PhaseJitter * r.Float64()
+	seed := r.Int63()
+
+	var n expandedNode
+	for k, t := range base.terms {
+		t.Start += float64(i) * group.Stagger
+		if time.Duration(t.Start*float64(time.Second)) > cfg.Timeframe {
+			continue
+		}
+		t.Value *= scale
+		t.Delta *= scale
+		t.Amplitude *= scale
+		if t.Type == "sine" {
+			t.Phase += phase
+		}
+		if t.Type == "noise" {
+			// Each instance gets its own noise.
+			t.Seed = seed + int64(k)
+		}
+		n.terms = append(n.terms, t)
+		n.termPaths = append(n.termPaths, base.termPaths[k])
+	}
+	return n
+}
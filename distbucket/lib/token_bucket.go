@@ -18,6 +18,12 @@ func TokenBucket(cfg *Config, requested PerNodeData) (granted PerNodeData, token
 
 	currTokens := cfg.InitialBurst
 
+	corr := make([]*corrections, len(requested))
+	for i := range corr {
+		corr[i] = newCorrections(cfg, i)
+	}
+	b := newBudget(cfg)
+
 	// Maintain the current tick per node that needs tokens; requested is 0 up to
 	// that tick.
 	ticks := make([]int, len(requested))
@@ -36,16 +42,22 @@ func TokenBucket(cfg *Config, requested PerNodeData) (granted PerNodeData, token
 	}
 
 	for now := range tokens {
+		b.tick(now)
 		// If we have more than MaxBurst, then the initial burst was larger and we
 		// are still using it.
 		if currTokens < cfg.MaxBurst {
-			currTokens += cfg.RatePerSec * tickDuration
+			currTokens += b.refillRate(cfg) * tickDuration
 			if currTokens > cfg.MaxBurst {
 				currTokens = cfg.MaxBurst
 			}
 		}
 		tokens[now] = currTokens
-		for currTokens > 0 {
+		for {
+			// With a hard stop, we never grant more than the remaining budget.
+			available := b.limit(currTokens)
+			if available <= 0 {
+				break
+			}
 			t := headOfQueue()
 			if t > now {
 				// All requests up to the current time have already been satisfied.
@@ -61,12 +73,14 @@ func TokenBucket(cfg *Config, requested PerNodeData) (granted PerNodeData, token
 				}
 			}
 			fraction := 1.0
-			if totalReq > currTokens {
+			if totalReq > available {
 				// We can only satisfy this fraction of the requested.
-				fraction = currTokens / totalReq
-				currTokens = 0
+				fraction = available / totalReq
+				currTokens -= available
+				b.charge(available)
 			} else {
 				currTokens -= totalReq
+				b.charge(totalReq)
 			}
 			// Give out to each node, proportionally to the ask.
 			for i := range ticks {
@@ -75,6 +89,12 @@ func TokenBucket(cfg *Config, requested PerNodeData) (granted PerNodeData, token
 				granted[i][now] += amount
 			}
 		}
+		for i := range corr {
+			if corr[i] != nil {
+				corr[i].granted(now, granted[i][now])
+				currTokens -= corr[i].due(now)
+			}
+		}
 	}
 	// Convert from absolute amount to rate.
 	for i := range granted {
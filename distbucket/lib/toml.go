@@ -0,0 +1,709 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// This file implements the subset of TOML (v1.0) needed for inputs: tables,
+// arrays of tables, dotted keys, inline tables and arrays, strings, numbers
+// and booleans. Dates and times are not supported.
+
+// tomlTable is a table, with the keys in the order in which they were defined.
+type tomlTable struct {
+	path   string
+	keys   []string
+	values map[string]interface{}
+	// defined is set if the table was defined with a header or an inline
+	// table; tables that are only implied by other definitions can still be
+	// defined.
+	defined bool
+	// inline is set for inline tables, which can't be extended.
+	inline bool
+}
+
+// tomlArrayOfTables is an array defined with [[header]] sections.
+type tomlArrayOfTables struct {
+	tables []*tomlTable
+}
+
+func newTOMLTable(path string) *tomlTable {
+	return &tomlTable{path: path, values: make(map[string]interface{})}
+}
+
+func (t *tomlTable) childPath(key string) string {
+	if t.path == "" {
+		return key
+	}
+	return t.path + "." + key
+}
+
+func (t *tomlTable) set(key string, v interface{}) {
+	if _, ok := t.values[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.values[key] = v
+}
+
+// tree converts the table to the same form as YAML decoding into a
+// yaml.MapSlice.
+func (t *tomlTable) tree() yaml.MapSlice {
+	m := yaml.MapSlice{}
+	for _, k := range t.keys {
+		m = append(m, yaml.MapItem{Key: k, Value: tomlTreeValue(t.values[k])})
+	}
+	return m
+}
+
+func tomlTreeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *tomlTable:
+		return v.tree()
+	case *tomlArrayOfTables:
+		list := make([]interface{}, len(v.tables))
+		for i := range v.tables {
+			list[i] = v.tables[i].tree()
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = tomlTreeValue(v[i])
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+type tomlParser struct {
+	text      string
+	pos       int
+	positions map[string]Position
+}
+
+// tomlError is a parsing error, at an offset in the text.
+type tomlError struct {
+	pos int
+	msg string
+}
+
+func (e *tomlError) Error() string {
+	return e.msg
+}
+
+// errorf returns a tomlError at the current position.
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &tomlError{pos: p.pos, msg: fmt.Sprintf(format, args...)}
+}
+
+// parseTOMLTree parses a TOML document into the same form as YAML decoding
+// into a yaml.MapSlice, along with the positions of all the values.
+func parseTOMLTree(text string) (yaml.MapSlice, map[string]Position, InputErrors) {
+	p := &tomlParser{text: text, positions: make(map[string]Position)}
+	root, err := p.parseDocument()
+	if err != nil {
+		e := err.(*tomlError)
+		pos := offsetPosition(text, e.pos)
+		return nil, p.positions, InputErrors{{Line: pos.Line, Column: pos.Column, Severity: SeverityError, Message: e.msg}}
+	}
+	return root.tree(), p.positions, nil
+}
+
+func (p *tomlParser) parseDocument() (*tomlTable, error) {
+	root := newTOMLTable("")
+	current := root
+	for {
+		p.skipSpace(true /* newlines */)
+		if p.pos >= len(p.text) {
+			return root, nil
+		}
+		start := p.position()
+		var err error
+		switch {
+		case strings.HasPrefix(p.text[p.pos:], "[["):
+			p.pos += 2
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]]"); err == nil {
+					current, err = p.arrayTable(root, keys, start)
+				}
+			}
+		case p.text[p.pos] == '[':
+			p.pos++
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]"); err == nil {
+					current, err = p.table(root, keys, start)
+				}
+			}
+		default:
+			err = p.parseKeyValue(current)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) position() Position {
+	return offsetPosition(p.text, p.pos)
+}
+
+// skipSpace skips whitespace and comments; newlines are skipped only if
+// newlines is set.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.text) {
+		switch c := p.text[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '\r' || c == '\n':
+			if !newlines {
+				return
+			}
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) expect(s string) error {
+	p.skipSpace(false)
+	if !strings.HasPrefix(p.text[p.pos:], s) {
+		return p.errorf("expected '%s'", s)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace(false)
+	if p.pos < len(p.text) && p.text[p.pos] != '\n' && p.text[p.pos] != '\r' {
+		return p.errorf("expected the end of the line")
+	}
+	return nil
+}
+
+// parseKey parses a (possibly dotted) key.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace(false)
+		if p.pos >= len(p.text) {
+			return nil, p.errorf("expected a key")
+		}
+		switch p.text[p.pos] {
+		case '"':
+			k, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		case '\'':
+			k, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		default:
+			start := p.pos
+			for p.pos < len(p.text) && isBareKeyChar(p.text[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key")
+			}
+			keys = append(keys, p.text[start:p.pos])
+		}
+		p.skipSpace(false)
+		if p.pos >= len(p.text) || p.text[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// descend returns the table for the given key in t, creating it if necessary;
+// for arrays of tables, it returns the last table.
+func (p *tomlParser) descend(t *tomlTable, key string) (*tomlTable, error) {
+	switch v := t.values[key].(type) {
+	case nil:
+		child := newTOMLTable(t.childPath(key))
+		t.set(key, child)
+		return child, nil
+	case *tomlTable:
+		if v.inline {
+			return nil, p.errorf("can't extend inline table '%s'", v.path)
+		}
+		return v, nil
+	case *tomlArrayOfTables:
+		return v.tables[len(v.tables)-1], nil
+	default:
+		return nil, p.errorf("key '%s' is already defined", t.childPath(key))
+	}
+}
+
+// descendAll descends into the tables for all the keys but the last one, and
+// returns the last table.
+func (p *tomlParser) descendAll(t *tomlTable, keys []string) (*tomlTable, error) {
+	for _, k := range keys[:len(keys)-1] {
+		var err error
+		if t, err = p.descend(t, k); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// table handles a [table] header.
+func (p *tomlParser) table(root *tomlTable, keys []string, pos Position) (*tomlTable, error) {
+	t, err := p.descendAll(root, keys)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	if existing, ok := t.values[last].(*tomlTable); ok && existing.defined {
+		return nil, p.errorf("table '%s' is already defined", existing.path)
+	}
+	if t, err = p.descend(t, last); err != nil {
+		return nil, err
+	}
+	t.defined = true
+	p.positions[t.path] = pos
+	return t, nil
+}
+
+// arrayTable handles a [[table]] header.
+func (p *tomlParser) arrayTable(root *tomlTable, keys []string, pos Position) (*tomlTable, error) {
+	t, err := p.descendAll(root, keys)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	arr, ok := t.values[last].(*tomlArrayOfTables)
+	if !ok {
+		if _, exists := t.values[last]; exists {
+			return nil, p.errorf("key '%s' is already defined", t.childPath(last))
+		}
+		arr = &tomlArrayOfTables{}
+		t.set(last, arr)
+		p.positions[t.childPath(last)] = pos
+	}
+	child := newTOMLTable(fmt.Sprintf("%s[%d]", t.childPath(last), len(arr.tables)))
+	child.defined = true
+	arr.tables = append(arr.tables, child)
+	p.positions[child.path] = pos
+	return child, nil
+}
+
+// parseKeyValue parses a "key = value" pair into the table.
+func (p *tomlParser) parseKeyValue(t *tomlTable) error {
+	pos := p.position()
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if t, err = p.descendAll(t, keys); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := t.values[last]; exists {
+		return p.errorf("key '%s' is already defined", t.childPath(last))
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	path := t.childPath(last)
+	p.positions[path] = pos
+	v, err := p.parseValue(path)
+	if err != nil {
+		return err
+	}
+	t.set(last, v)
+	return nil
+}
+
+var tomlNumberRegexp = regexp.MustCompile(`^[+-]?(?:[0-9_]+(?:\.[0-9_]+)?(?:[eE][+-]?[0-9_]+)?|0x[0-9a-fA-F_]+|0o[0-7_]+|0b[01_]+)$`)
+
+func (p *tomlParser) parseValue(path string) (interface{}, error) {
+	p.skipSpace(false)
+	if p.pos >= len(p.text) {
+		return nil, p.errorf("expected a value")
+	}
+	switch p.text[p.pos] {
+	case '"':
+		return p.parseBasicString()
+	case '\'':
+		return p.parseLiteralString()
+	case '[':
+		return p.parseArray(path)
+	case '{':
+		return p.parseInlineTable(path)
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && strings.IndexByte(" \t\r\n,]}#", p.text[p.pos]) < 0 {
+		p.pos++
+	}
+	tok := p.text[start:p.pos]
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	if !tomlNumberRegexp.MatchString(tok) || strings.Contains(tok, "__") {
+		p.pos = start
+		if strings.ContainsAny(tok, ":") || strings.Count(tok, "-") >= 2 {
+			return nil, p.errorf("dates and times are not supported")
+		}
+		return nil, p.errorf("invalid value '%s'", tok)
+	}
+	clean := strings.ReplaceAll(tok, "_", "")
+	unsigned := strings.TrimLeft(clean, "+-")
+	if len(unsigned) > 2 && unsigned[0] == '0' && strings.IndexByte("xob", unsigned[1]) >= 0 {
+		if i, err := strconv.ParseInt(clean, 0, 64); err == nil {
+			return i, nil
+		}
+		p.pos = start
+		return nil, p.errorf("invalid number '%s'", tok)
+	}
+	if !strings.ContainsAny(clean, ".eE") {
+		if i, err := strconv.ParseInt(clean, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+	f, err := strconv.ParseFloat(clean, 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid number '%s'", tok)
+	}
+	return f, nil
+}
+
+func (p *tomlParser) parseArray(path string) ([]interface{}, error) {
+	p.pos++
+	list := []interface{}{}
+	for {
+		p.skipSpace(true /* newlines */)
+		if p.pos < len(p.text) && p.text[p.pos] == ']' {
+			p.pos++
+			return list, nil
+		}
+		elemPath := fmt.Sprintf("%s[%d]", path, len(list))
+		p.positions[elemPath] = p.position()
+		v, err := p.parseValue(elemPath)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		p.skipSpace(true /* newlines */)
+		if p.pos < len(p.text) && p.text[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.text) || p.text[p.pos] != ']' {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable(path string) (*tomlTable, error) {
+	p.pos++
+	t := newTOMLTable(path)
+	t.defined = true
+	for {
+		p.skipSpace(false)
+		if p.pos < len(p.text) && p.text[p.pos] == '}' && len(t.keys) == 0 {
+			p.pos++
+			break
+		}
+		if err := p.parseKeyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.pos < len(p.text) && p.text[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect("}"); err != nil {
+			return nil, err
+		}
+		break
+	}
+	t.inline = true
+	return t, nil
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	multiline := strings.HasPrefix(p.text[p.pos:], `"""`)
+	if multiline {
+		p.pos += 3
+		// A newline right after the delimiter is trimmed.
+		if strings.HasPrefix(p.text[p.pos:], "\r\n") {
+			p.pos += 2
+		} else if strings.HasPrefix(p.text[p.pos:], "\n") {
+			p.pos++
+		}
+	} else {
+		p.pos++
+	}
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.text) {
+			return "", p.errorf("unterminated string")
+		}
+		if multiline && strings.HasPrefix(p.text[p.pos:], `"""`) {
+			p.pos += 3
+			return b.String(), nil
+		}
+		c := p.text[p.pos]
+		switch {
+		case c == '"' && !multiline:
+			p.pos++
+			return b.String(), nil
+		case c == '\n' && !multiline:
+			return "", p.errorf("unterminated string")
+		case c == '\\':
+			p.pos++
+			if p.pos >= len(p.text) {
+				return "", p.errorf("unterminated string")
+			}
+			esc := p.text[p.pos]
+			p.pos++
+			switch esc {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(esc)
+			case 'u', 'U':
+				n := 4
+				if esc == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.text) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.text[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				p.pos += n
+			default:
+				if multiline && (esc == '\n' || esc == ' ' || esc == '\t' || esc == '\r') {
+					// Line ending backslash: trim all whitespace up to the next
+					// non-whitespace character.
+					for p.pos < len(p.text) && strings.IndexByte(" \t\r\n", p.text[p.pos]) >= 0 {
+						p.pos++
+					}
+					continue
+				}
+				p.pos -= 2
+				return "", p.errorf("invalid escape sequence")
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	delim := "'"
+	if strings.HasPrefix(p.text[p.pos:], "'''") {
+		delim = "'''"
+	}
+	p.pos += len(delim)
+	if delim == "'''" {
+		if strings.HasPrefix(p.text[p.pos:], "\r\n") {
+			p.pos += 2
+		} else if strings.HasPrefix(p.text[p.pos:], "\n") {
+			p.pos++
+		}
+	}
+	end := strings.Index(p.text[p.pos:], delim)
+	if end < 0 || (delim == "'" && strings.Contains(p.text[p.pos:p.pos+end], "\n")) {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.text[p.pos : p.pos+end]
+	p.pos += end + len(delim)
+	return s, nil
+}
+
+// writeTOML writes a table decoded from YAML (see Input.Marshal) as TOML. The
+// keys with simple values come first, followed by the tables and the arrays of
+// tables.
+func writeTOML(b *strings.Builder, path []string, m yaml.MapSlice) error {
+	isTableArray := func(v interface{}) bool {
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return false
+		}
+		for i := range list {
+			if _, ok := list[i].(yaml.MapSlice); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	for _, item := range m {
+		if item.Value == nil || isTableArray(item.Value) {
+			continue
+		}
+		if _, ok := item.Value.(yaml.MapSlice); ok {
+			continue
+		}
+		value, err := tomlInlineValue(item.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s = %s\n", tomlKey(fmt.Sprint(item.Key)), value)
+	}
+	for _, item := range m {
+		if sub, ok := item.Value.(yaml.MapSlice); ok {
+			subPath := append(path[:len(path):len(path)], tomlKey(fmt.Sprint(item.Key)))
+			fmt.Fprintf(b, "\n[%s]\n", strings.Join(subPath, "."))
+			if err := writeTOML(b, subPath, sub); err != nil {
+				return err
+			}
+		}
+	}
+	for _, item := range m {
+		if isTableArray(item.Value) {
+			subPath := append(path[:len(path):len(path)], tomlKey(fmt.Sprint(item.Key)))
+			for _, v := range item.Value.([]interface{}) {
+				fmt.Fprintf(b, "\n[[%s]]\n", strings.Join(subPath, "."))
+				if err := writeTOML(b, subPath, v.(yaml.MapSlice)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func tomlKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isBareKeyChar(key[i]) {
+			return tomlString(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// tomlInlineValue returns the TOML representation of a value.
+func tomlInlineValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return tomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		case math.IsNaN(v):
+			return "nan", nil
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i := range v {
+			var err error
+			if elems[i], err = tomlInlineValue(v[i]); err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	case yaml.MapSlice:
+		elems := make([]string, 0, len(v))
+		for _, item := range v {
+			if item.Value == nil {
+				continue
+			}
+			value, err := tomlInlineValue(item.Value)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, tomlKey(fmt.Sprint(item.Key))+" = "+value)
+		}
+		if len(elems) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(elems, ", ") + " }", nil
+	default:
+		return "", fmt.Errorf("value %v can't be represented in TOML", v)
+	}
+}
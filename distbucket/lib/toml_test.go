@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseTOMLTree(t *testing.T) {
+	testCases := []struct {
+		name string
+		toml string
+		// yaml is the expected tree, in YAML form.
+		yaml string
+	}{
+		{
+			name: "inline tables",
+			toml: `
+config = { rate_per_sec = 100, tick = "10ms" }
+nodes = [{ terms = [{ type = "constant", value = 5 }] }]
+`,
+			yaml: `
+config:
+  rate_per_sec: 100
+  tick: 10ms
+nodes:
+- terms:
+  - type: constant
+    value: 5
+`,
+		},
+		{
+			name: "arrays of tables",
+			toml: `
+[[nodes]]
+[[nodes.terms]]
+type = "constant"
+value = 100
+
+[[nodes.terms]]
+type = "ramp"
+delta = -10
+
+[[nodes]]
+count = 2
+[[nodes.terms]]
+type = "sine"
+`,
+			yaml: `
+nodes:
+- terms:
+  - type: constant
+    value: 100
+  - type: ramp
+    delta: -10
+- count: 2
+  terms:
+  - type: sine
+`,
+		},
+		{
+			name: "dotted keys",
+			toml: `
+config.rate_per_sec = 100
+[output]
+charts = ["ewma", 'shares']
+`,
+			yaml: `
+config:
+  rate_per_sec: 100
+output:
+  charts:
+  - ewma
+  - shares
+`,
+		},
+		{
+			name: "numbers",
+			toml: `
+a = 1_000
+b = -2.5e3
+c = 0x10
+d = +inf
+e = 1_0.2_5
+`,
+			yaml: `
+a: 1000
+b: -2500
+c: 16
+d: .inf
+e: 10.25
+`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, _, errs := parseTOMLTree(tc.toml)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			out, err := yaml.Marshal(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual, expected := string(out), strings.TrimPrefix(tc.yaml, "\n"); actual != expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		toml string
+		// errors are the expected errors, as reported by Process.
+		errors []string
+	}{
+		{
+			name: "unknown config key",
+			toml: `
+[config]
+rate_per_sec = 100
+foo = 1
+`,
+			errors: []string{"line 4: config.foo: field foo not found in type lib.Config"},
+		},
+		{
+			name: "unknown key in array of tables",
+			toml: `
+[[nodes]]
+[[nodes.terms]]
+type = "constant"
+bar = 1
+`,
+			errors: []string{"line 5: nodes[0].terms[0].bar: field bar not found in type lib.FuncTerm"},
+		},
+		{
+			name: "duplicate key",
+			toml: `
+[config]
+tick = "10ms"
+tick = "20ms"
+`,
+			errors: []string{"line 4: key 'config.tick' is already defined"},
+		},
+		{
+			name: "invalid number",
+			toml: `
+[config]
+rate_per_sec = 1__000
+`,
+			errors: []string{"line 3: invalid value '1__000'"},
+		},
+		{
+			name: "unterminated string",
+			toml: `
+[[nodes]]
+[[nodes.terms]]
+type = "constant
+`,
+			errors: []string{"line 4: unterminated string"},
+		},
+		{
+			name: "extending an inline table",
+			toml: `
+config = { rate_per_sec = 100 }
+config.tick = "10ms"
+`,
+			errors: []string{"line 3: can't extend inline table 'config'"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []string
+			for _, e := range InputErrors(ProcessFormat(tc.toml, FormatTOML).Errors).Filter(SeverityError) {
+				actual = append(actual, e.Error())
+			}
+			if strings.Join(actual, "\n") != strings.Join(tc.errors, "\n") {
+				t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(tc.errors, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position is a 1-based line and column in the input.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// yamlPositions maps the paths of the settings in a YAML document (e.g.
+// "nodes[2].terms[1].start") to their positions. Only block style collections
+// are indexed; the contents of flow style collections ("[1, 2]" or "{a: 1}")
+// and block scalars are skipped.
+//
+// This is a best-effort scan used to point out errors; it does not validate
+// the document (the YAML parser does that).
+func yamlPositions(text string) map[string]Position {
+	type frame struct {
+		indent int
+		isSeq  bool
+		path   string
+		// For mappings, lastKey is the path of the last key; for sequences,
+		// count is the number of items so far.
+		lastKey string
+		count   int
+	}
+	var stack []frame
+	top := func() *frame {
+		if len(stack) == 0 {
+			return nil
+		}
+		return &stack[len(stack)-1]
+	}
+	// childPath returns the path of the value of the last key or item in the
+	// top frame.
+	childPath := func() string {
+		f := top()
+		switch {
+		case f == nil:
+			return ""
+		case f.isSeq:
+			return fmt.Sprintf("%s[%d]", f.path, f.count-1)
+		default:
+			return f.lastKey
+		}
+	}
+	positions := make(map[string]Position)
+	// Lines indented more than skipIndent belong to a block scalar.
+	skipIndent := -1
+
+	for lineIdx, line := range strings.Split(text, "\n") {
+		content := strings.TrimLeft(line, " ")
+		col := len(line) - len(content)
+		content = stripYAMLComment(content)
+		if content == "" || content == "---" || content == "..." {
+			continue
+		}
+		if skipIndent >= 0 {
+			if col > skipIndent {
+				continue
+			}
+			skipIndent = -1
+		}
+
+		// Process any sequence item markers ("- - a: 1").
+		for content == "-" || strings.HasPrefix(content, "- ") {
+			for f := top(); f != nil && f.indent > col; f = top() {
+				stack = stack[:len(stack)-1]
+			}
+			if f := top(); f == nil || f.indent < col || !f.isSeq {
+				// Start a new sequence; this also handles sequences at the same
+				// indentation as their parent key.
+				stack = append(stack, frame{indent: col, isSeq: true, path: childPath()})
+			}
+			f := top()
+			f.count++
+			positions[childPath()] = Position{Line: lineIdx + 1, Column: col + 1}
+
+			rest := strings.TrimLeft(content[1:], " ")
+			col += len(content) - len(rest)
+			content = rest
+		}
+		if content == "" {
+			continue
+		}
+
+		key, value, ok := splitYAMLKey(content)
+		if !ok {
+			// A scalar (or flow collection); nothing to index.
+			continue
+		}
+		for f := top(); f != nil && (f.indent > col || (f.indent == col && f.isSeq)); f = top() {
+			stack = stack[:len(stack)-1]
+		}
+		if f := top(); f == nil || f.indent < col {
+			stack = append(stack, frame{indent: col, path: childPath()})
+		}
+		f := top()
+		if f.path == "" {
+			f.lastKey = key
+		} else {
+			f.lastKey = f.path + "." + key
+		}
+		positions[f.lastKey] = Position{Line: lineIdx + 1, Column: col + 1}
+
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			skipIndent = col
+		}
+	}
+	return positions
+}
+
+// splitYAMLKey splits a "key: value" line. Returns ok=false if the line is not
+// a mapping entry.
+func splitYAMLKey(content string) (key, value string, ok bool) {
+	if strings.HasPrefix(content, "[") || strings.HasPrefix(content, "{") {
+		return "", "", false
+	}
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '"', '\'':
+			// Skip over a quoted key.
+			if j := strings.IndexByte(content[i+1:], content[i]); j >= 0 {
+				i += j + 1
+			}
+		case ':':
+			if i+1 == len(content) || content[i+1] == ' ' {
+				key = strings.Trim(strings.TrimSpace(content[:i]), `"'`)
+				return key, strings.TrimSpace(content[i+1:]), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes a trailing comment, ignoring '#' characters inside
+// quotes.
+func stripYAMLComment(content string) string {
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || content[i-1] == ' '):
+			return strings.TrimRight(content[:i], " ")
+		}
+	}
+	return strings.TrimRight(content, " ")
+}
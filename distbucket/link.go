@@ -0,0 +1,59 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/link"
+)
+
+// runLink encodes an input file into a link to the distbucket page, or decodes
+// a link back into YAML.
+func runLink(args []string) error {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	baseURL := flags.String("url", "https://raduberinde.github.io/distbucket/", "page URL used for encoded links")
+	decode := flags.Bool("d", false, "decode a link (or its fragment) instead of encoding an input file")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  distbucket link [-url <page-url>] <input-file>\n  distbucket link -d <link>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *decode {
+		in, err := link.Decode(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		text, err := in.YAML()
+		if err != nil {
+			return err
+		}
+		fmt.Print(text)
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	in, err := lib.ParseInput(string(data))
+	if err != nil {
+		return err
+	}
+	s, err := link.Encode(in)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s#%s\n", *baseURL, s)
+	return nil
+}
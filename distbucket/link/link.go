@@ -0,0 +1,81 @@
+// Package link encodes inputs into compact strings that can be used in URLs
+// (e.g. as the fragment of a link to the distbucket page), and decodes them
+// back. It works both natively and with GopherJS.
+//
+// The encoding is a version byte followed by the zlib-compressed canonical
+// YAML form of the input, all in unpadded base64url. The other viewers in
+// this repository (lsmview, treesteps) use the same deflate+base64url scheme,
+// without the version byte.
+package link
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// Version is the version of the encoding; it is incremented if the encoding
+// changes. Changes to the input schema don't require a new version (the input
+// contains its own version).
+const Version byte = 1
+
+// maxDecodedSize limits the size of a decoded input, to guard against
+// malicious links.
+const maxDecodedSize = 1 << 20
+
+// Encode returns the encoded form of the input.
+func Encode(in lib.Input) (string, error) {
+	text, err := in.Marshal(lib.FormatYAML)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(Version)
+	w, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write([]byte(text)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Decode decodes an input encoded with Encode. The argument can also be a
+// full URL, in which case the fragment is decoded.
+func Decode(s string) (lib.Input, error) {
+	if i := strings.LastIndexByte(s, '#'); i >= 0 {
+		s = s[i+1:]
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(s), "="))
+	if err != nil {
+		return lib.Input{}, fmt.Errorf("invalid link: %v", err)
+	}
+	if len(data) == 0 {
+		return lib.Input{}, fmt.Errorf("invalid link: empty")
+	}
+	if data[0] != Version {
+		return lib.Input{}, fmt.Errorf("unsupported link version %d (current version is %d)", data[0], Version)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return lib.Input{}, fmt.Errorf("invalid link: %v", err)
+	}
+	text, err := ioutil.ReadAll(io.LimitReader(r, maxDecodedSize+1))
+	if err != nil {
+		return lib.Input{}, fmt.Errorf("invalid link: %v", err)
+	}
+	if len(text) > maxDecodedSize {
+		return lib.Input{}, fmt.Errorf("invalid link: input larger than %d bytes", maxDecodedSize)
+	}
+	return lib.ParseInputFormat(string(text), lib.FormatYAML)
+}
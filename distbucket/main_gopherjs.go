@@ -1,12 +1,32 @@
+//go:build gopherjs
+// +build gopherjs
+
 package main
 
 import (
 	"github.com/gopherjs/gopherjs/js"
 	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/workloads"
 )
 
 func main() {
 	js.Global.Set("Process", lib.Process)
+	js.Global.Set("ConfigSchema", lib.ConfigSchema)
+	js.Global.Set("NewSimulation", newSimulation)
+	js.Global.Set("EncodeLink", encodeLink)
+	js.Global.Set("DecodeLink", decodeLink)
+	js.Global.Set("Workloads", workloads.All)
+}
+
+// newSimulation sets up a lib.Simulation for an input YAML; the result exposes
+// the Simulation methods (Step, RunUntil, Snapshot, etc). On error, the result
+// is an object with an Error field.
+func newSimulation(inputYAML string) interface{} {
+	sim, err := lib.NewSimulationFromYAML(inputYAML)
+	if err != nil {
+		return js.M{"Error": err.Error()}
+	}
+	return js.MakeWrapper(sim)
 }
 
 
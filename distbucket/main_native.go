@@ -0,0 +1,86 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a subcommand of the native distbucket binary.
+type command struct {
+	help string
+	run  func(args []string) error
+}
+
+var commands = map[string]command{
+	"convert": {
+		help: "convert an input file between the YAML, JSON and TOML formats",
+		run:  runConvert,
+	},
+	"emulate": {
+		help: "run the distributed token bucket in real time, with the global bucket behind a local server",
+		run:  runEmulate,
+	},
+	"events": {
+		help: "write the log of global bucket requests for an input YAML file",
+		run:  runEvents,
+	},
+	"fit": {
+		help: "fit function terms to recorded per-node rates and write an input YAML file",
+		run:  runFit,
+	},
+	"link": {
+		help: "encode an input file into a shareable link, or decode a link",
+		run:  runLink,
+	},
+	"export": {
+		help: "write the charts for an input file as CSV, columnar binary or OpenMetrics",
+		run:  runExport,
+	},
+	"report": {
+		help: "write a self-contained HTML report for an input file",
+		run:  runReport,
+	},
+	"serve": {
+		help: "serve the UI and an HTTP API for running simulations",
+		run:  runServe,
+	},
+	"workloads": {
+		help: "list the workload catalog, or print a workload",
+		run:  runWorkloads,
+	},
+	"fuzz": {
+		help: "run random workloads and check the token bucket invariants",
+		run:  runFuzz,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: distbucket <command> [flags]\n\nCommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].help)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,210 @@
+//go:build js && wasm && !gopherjs
+// +build js,wasm,!gopherjs
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"syscall/js"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/workloads"
+)
+
+// This is the WebAssembly entry point; it exposes the same functions as the
+// GopherJS build (see main_gopherjs.go). Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o distbucket.wasm .
+
+func main() {
+	js.Global().Set("Process", export(lib.Process))
+	js.Global().Set("ConfigSchema", export(lib.ConfigSchema))
+	js.Global().Set("NewSimulation", export(newSimulation))
+	js.Global().Set("EncodeLink", export(encodeLink))
+	js.Global().Set("DecodeLink", export(decodeLink))
+	js.Global().Set("Workloads", export(workloads.All))
+	// The exported functions are called from JS after main returns.
+	select {}
+}
+
+// newSimulation sets up a lib.Simulation for an input YAML; the result exposes
+// the Simulation methods (Step, RunUntil, Snapshot, etc). On error, the result
+// is an object with an Error field.
+func newSimulation(inputYAML string) interface{} {
+	sim, err := lib.NewSimulationFromYAML(inputYAML)
+	if err != nil {
+		return map[string]interface{}{"Error": err.Error()}
+	}
+	return wrapper(reflect.ValueOf(sim))
+}
+
+// export converts a Go function to a JS function. The arguments are converted
+// to the parameter types (see fromJS) and the results are converted with toJS;
+// functions with multiple results return an array.
+func export(fn interface{}) js.Func {
+	return exportValue(reflect.ValueOf(fn))
+}
+
+// A panic (e.g. because of an unsupported argument) is returned as a JS Error
+// object; unlike in GopherJS, an unrecovered panic would exit the Go program,
+// after which none of the exported functions work.
+func exportValue(fn reflect.Value) js.Func {
+	t := fn.Type()
+	return js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = js.Global().Get("Error").New(fmt.Sprint(r))
+			}
+		}()
+		in := make([]reflect.Value, 0, len(args))
+		for i, arg := range args {
+			var pt reflect.Type
+			switch {
+			case t.IsVariadic() && i >= t.NumIn()-1:
+				pt = t.In(t.NumIn() - 1).Elem()
+			case i < t.NumIn():
+				pt = t.In(i)
+			default:
+				// Extra arguments are ignored, like in JS.
+				continue
+			}
+			in = append(in, fromJS(arg, pt))
+		}
+		for len(in) < t.NumIn() && !(t.IsVariadic() && len(in) == t.NumIn()-1) {
+			in = append(in, reflect.Zero(t.In(len(in))))
+		}
+		out := fn.Call(in)
+		switch len(out) {
+		case 0:
+			return nil
+		case 1:
+			return toJS(out[0])
+		}
+		res := make([]interface{}, len(out))
+		for i := range out {
+			res[i] = toJS(out[i])
+		}
+		return res
+	})
+}
+
+// wrapper returns a JS object with the exported methods of a value, like
+// js.MakeWrapper in GopherJS. The functions are never released.
+func wrapper(v reflect.Value) js.Value {
+	obj := js.Global().Get("Object").New()
+	for i := 0; i < v.NumMethod(); i++ {
+		obj.Set(v.Type().Method(i).Name, exportValue(v.Method(i)))
+	}
+	return obj
+}
+
+// fromJS converts a JS value to a Go value of the given type. Only the types
+// used by the exported functions are supported.
+func fromJS(v js.Value, t reflect.Type) reflect.Value {
+	res := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		res.SetString(v.String())
+	case reflect.Bool:
+		res.SetBool(v.Truthy())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		res.SetInt(int64(v.Float()))
+	case reflect.Float32, reflect.Float64:
+		res.SetFloat(v.Float())
+	default:
+		panic(fmt.Sprintf("unsupported parameter type %s", t))
+	}
+	return res
+}
+
+var (
+	float64Array = js.Global().Get("Float64Array")
+	uint8Array   = js.Global().Get("Uint8Array")
+
+	jsValueType = reflect.TypeOf(js.Value{})
+	jsFuncType  = reflect.TypeOf(js.Func{})
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// toJS converts a Go value to a JS value, in the same form as GopherJS:
+// structs become objects with the exported fields, maps become objects, and
+// slices become arrays (nil slices become null). Slices of float64 (like the
+// chart data) are copied in bulk into a Float64Array. Errors become JS Error
+// objects.
+func toJS(v reflect.Value) interface{} {
+	if t := v.Type(); t == jsValueType || t == jsFuncType {
+		return v.Interface()
+	}
+	if v.Type().Implements(errorType) {
+		if v.Kind() == reflect.Interface && v.IsNil() {
+			return nil
+		}
+		return js.Global().Get("Error").New(v.Interface().(error).Error())
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return toJS(v.Elem())
+
+	case reflect.Struct:
+		obj := js.Global().Get("Object").New()
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				obj.Set(f.Name, toJS(v.Field(i)))
+			}
+		}
+		return obj
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		obj := js.Global().Get("Object").New()
+		iter := v.MapRange()
+		for iter.Next() {
+			obj.Set(fmt.Sprint(iter.Key().Interface()), toJS(iter.Value()))
+		}
+		return obj
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Float64 {
+			n := v.Len()
+			buf := make([]byte, 8*n)
+			for i := 0; i < n; i++ {
+				binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(v.Index(i).Float()))
+			}
+			bytes := uint8Array.New(len(buf))
+			js.CopyBytesToJS(bytes, buf)
+			return float64Array.New(bytes.Get("buffer"))
+		}
+		arr := js.Global().Get("Array").New(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			arr.SetIndex(i, toJS(v.Index(i)))
+		}
+		return arr
+
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Func:
+		return exportValue(v)
+	default:
+		panic(fmt.Sprintf("unsupported type %s", v.Type()))
+	}
+}
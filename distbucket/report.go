@@ -0,0 +1,56 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/report"
+)
+
+// runReport processes an input file and writes a self-contained HTML report
+// with the charts, the metrics, the config and the input.
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	outFile := flags.String("o", "", "output file (default: the input file name, with the .html extension)")
+	title := flags.String("title", "", "report title (default: the input file name)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket report [flags] <input-file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	inFile := flags.Arg(0)
+	data, err := ioutil.ReadFile(inFile)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(inFile, filepath.Ext(inFile))
+	if *outFile == "" {
+		*outFile = base + ".html"
+	}
+	if *title == "" {
+		*title = filepath.Base(base)
+	}
+	err = writeFile(*outFile, func(w io.Writer) error {
+		return report.Write(w, *title, string(data))
+	})
+	if err != nil {
+		// Don't leave a partial report behind.
+		os.Remove(*outFile)
+		return err
+	}
+	fmt.Println(*outFile)
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package report generates self-contained HTML reports for simulations. A
+// report contains the charts, the input, the config and the metrics; it embeds
+// the chart library and the data, so it can be viewed offline.
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// d3 is the same version used by the lsmview and treesteps pages.
+//
+//go:embed d3.v7.min.js
+var d3 string
+
+//go:embed report.html
+var reportHTML string
+
+var reportTemplate = template.Must(template.New("report").Parse(reportHTML))
+
+// ConfigRow is a row in the config table of a report.
+type ConfigRow struct {
+	Label   string
+	Key     string
+	Value   string
+	Default bool
+}
+
+type reportData struct {
+	Title     string
+	Generated string
+	Input     string
+	Config    []ConfigRow
+	Output    lib.Output
+	Warnings  []string
+	D3        template.JS
+}
+
+// Write generates a report for an input, in any of the formats accepted by
+// lib.Process. Returns an error if the input is not valid.
+func Write(w io.Writer, title string, input string) error {
+	in, err := lib.ParseInput(input)
+	if err != nil {
+		return err
+	}
+	out := lib.Process(input)
+	if out.Error != "" {
+		return fmt.Errorf("%s", out.Error)
+	}
+	// The events are only used for the chart markers.
+	out.Events = nil
+
+	data := reportData{
+		Title:     title,
+		Generated: time.Now().Format("2006-01-02 15:04:05 MST"),
+		Input:     input,
+		Output:    out,
+		D3:        template.JS(d3),
+	}
+	for _, f := range lib.ConfigSchema() {
+		v, _ := in.Config.Get(f.Key)
+		data.Config = append(data.Config, ConfigRow{
+			Label:   f.Label,
+			Key:     f.Key,
+			Value:   strconv.FormatFloat(v, 'f', -1, 64),
+			Default: v == f.Default,
+		})
+	}
+	for _, e := range out.Errors {
+		data.Warnings = append(data.Warnings, e.Error())
+	}
+	return reportTemplate.Execute(w, &data)
+}
@@ -0,0 +1,305 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/workloads"
+)
+
+// assets contains the files of the UI.
+//
+//go:embed index.html view.html distbucket.js distbucket.js.map
+var assets embed.FS
+
+// simServer implements the HTTP API (see runServe).
+type simServer struct {
+	maxRequestSize int64
+	maxSize        int64
+	timeout        time.Duration
+	// sims limits the number of concurrent simulations.
+	sims chan struct{}
+}
+
+// runServe serves the UI and an HTTP API for running simulations:
+//
+//	POST /simulate         input (YAML, JSON or TOML) -> Output JSON
+//	POST /simulate/stream  input -> JSON lines with the results, as they are
+//	                       simulated (distributed token bucket only)
+//	GET  /workloads        the workload catalog, as JSON
+//	GET  /workloads/<name> the input of a workload in the catalog
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	maxRequestSize := flags.Int64("max-request-size", 1<<20, "maximum size of a request body, in bytes")
+	maxSize := flags.Int64(
+		"max-size", 20000000,
+		"maximum size of a simulation: the number of ticks times the number of nodes, times the number of runs",
+	)
+	timeout := flags.Duration("timeout", 30*time.Second, "maximum duration of a simulation")
+	maxConcurrent := flags.Int("max-concurrent", runtime.NumCPU(), "maximum number of concurrent simulations")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: distbucket serve [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 || *maxConcurrent < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	s := &simServer{
+		maxRequestSize: *maxRequestSize,
+		maxSize:        *maxSize,
+		timeout:        *timeout,
+		sims:           make(chan struct{}, *maxConcurrent),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+	mux.HandleFunc("/simulate", s.simulate)
+	mux.HandleFunc("/simulate/stream", s.simulateStream)
+	mux.HandleFunc("/workloads", s.workloads)
+	mux.HandleFunc("/workloads/", s.workload)
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("serving on http://%s/", *addr)
+	return httpServer.ListenAndServe()
+}
+
+// readInput reads the input from the body of a POST request and checks that it
+// can be parsed and that the simulation is not too large. On error, it writes
+// the response and returns false; problems with the input are reported with
+// inputError.
+func (s *simServer) readInput(
+	w http.ResponseWriter, r *http.Request, inputError func(err error),
+) (string, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reading request: %v", err), http.StatusRequestEntityTooLarge)
+		return "", false
+	}
+	// The memory used by a simulation is proportional to its size, so we check
+	// it up front.
+	in, err := lib.ParseInput(string(body))
+	if err != nil {
+		inputError(err)
+		return "", false
+	}
+	runs := int64(2)
+	if len(in.Variants) > 0 {
+		runs = int64(len(in.Variants))
+	}
+	size := int64(in.Config.NumTicks()) * int64(in.NumNodes()) * runs
+	if size > s.maxSize {
+		http.Error(
+			w, fmt.Sprintf("simulation too large: %d ticks, %d nodes, %d runs (limit: %d)",
+				in.Config.NumTicks(), in.NumNodes(), runs, s.maxSize),
+			http.StatusRequestEntityTooLarge,
+		)
+		return "", false
+	}
+	return string(body), true
+}
+
+// acquire waits for a simulation slot; returns false if the context is done
+// first.
+func (s *simServer) acquire(ctx context.Context) bool {
+	select {
+	case s.sims <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *simServer) release() {
+	<-s.sims
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// simulate runs Process on the input and returns the Output. A simulation that
+// times out is stopped (see lib.ProcessContext).
+func (s *simServer) simulate(w http.ResponseWriter, r *http.Request) {
+	input, ok := s.readInput(w, r, func(err error) {
+		out := lib.Output{Error: err.Error()}
+		if errs, ok := err.(lib.InputErrors); ok {
+			out.Errors = errs
+		}
+		writeJSON(w, http.StatusBadRequest, out)
+	})
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	if !s.acquire(ctx) {
+		http.Error(w, "too many concurrent simulations", http.StatusServiceUnavailable)
+		return
+	}
+	done := make(chan lib.Output, 1)
+	go func() {
+		defer s.release()
+		done <- lib.ProcessContext(ctx, input, lib.FormatAuto)
+	}()
+	select {
+	case out := <-done:
+		status := http.StatusOK
+		if out.Error != "" {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, out)
+	case <-ctx.Done():
+		http.Error(w, fmt.Sprintf("simulation did not finish within %s", s.timeout), http.StatusServiceUnavailable)
+	}
+}
+
+// streamMessage is a line in the response of /simulate/stream. The first
+// message describes the simulation, the following ones contain the results
+// for consecutive ranges of ticks, and the last one has Done or Error set.
+type streamMessage struct {
+	Nodes    int `json:",omitempty"`
+	NumTicks int `json:",omitempty"`
+
+	// TimeAxis contains the times of the ticks in the message; Requested and
+	// Granted contain the rates for each node, at these ticks.
+	TimeAxis     []float64   `json:",omitempty"`
+	Requested    [][]float64 `json:",omitempty"`
+	Granted      [][]float64 `json:",omitempty"`
+	GlobalTokens []float64   `json:",omitempty"`
+
+	Done  bool   `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+// streamInterval is how often results are sent by /simulate/stream.
+const streamInterval = 100 * time.Millisecond
+
+// simulateStream runs the distributed token bucket on the input and streams
+// the results as JSON lines. If the simulation times out, the results so far
+// are followed by an error message.
+func (s *simServer) simulateStream(w http.ResponseWriter, r *http.Request) {
+	input, ok := s.readInput(w, r, func(err error) {
+		writeJSON(w, http.StatusBadRequest, streamMessage{Error: err.Error()})
+	})
+	if !ok {
+		return
+	}
+	sim, err := lib.NewSimulationFromYAML(input)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, streamMessage{Error: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	if !s.acquire(ctx) {
+		http.Error(w, "too many concurrent simulations", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.release()
+	sim.SetContext(ctx)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	send := func(msg streamMessage) {
+		enc.Encode(msg)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	cfg := sim.Config()
+	nodes := len(sim.Snapshot().Nodes)
+	send(streamMessage{Nodes: nodes, NumTicks: cfg.NumTicks()})
+
+	// sendResults sends the results for the ticks simulated since the last
+	// call.
+	sent := 0
+	sendResults := func() error {
+		if sent == sim.Now() {
+			return nil
+		}
+		msg := streamMessage{
+			Requested: make([][]float64, nodes),
+			Granted:   make([][]float64, nodes),
+		}
+		for ; sent < sim.Now(); sent++ {
+			requested, granted, tokens, err := sim.TickResults(sent)
+			if err != nil {
+				return err
+			}
+			msg.TimeAxis = append(msg.TimeAxis, cfg.TimeForTick(sent).Seconds())
+			for i := 0; i < nodes; i++ {
+				msg.Requested[i] = append(msg.Requested[i], requested[i])
+				msg.Granted[i] = append(msg.Granted[i], granted[i])
+			}
+			msg.GlobalTokens = append(msg.GlobalTokens, tokens)
+		}
+		send(msg)
+		return nil
+	}
+
+	lastSend := time.Now()
+	for sim.Step() {
+		if time.Since(lastSend) >= streamInterval {
+			if err := sendResults(); err != nil {
+				send(streamMessage{Error: err.Error()})
+				return
+			}
+			lastSend = time.Now()
+		}
+	}
+	if err := sendResults(); err != nil {
+		send(streamMessage{Error: err.Error()})
+		return
+	}
+	if sim.Err() != nil {
+		send(streamMessage{Error: fmt.Sprintf("simulation did not finish within %s", s.timeout)})
+		return
+	}
+	send(streamMessage{Done: true})
+}
+
+// workloads returns the workload catalog (see package workloads).
+func (s *simServer) workloads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, workloads.All())
+}
+
+// workload returns the input of a workload in the catalog, with the
+// recommended config settings.
+func (s *simServer) workload(w http.ResponseWriter, r *http.Request) {
+	wl, ok := workloads.Get(strings.TrimPrefix(r.URL.Path, "/workloads/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	fmt.Fprint(w, wl.InputWithConfig())
+}
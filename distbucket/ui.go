@@ -0,0 +1,103 @@
+//go:build gopherjs || (js && wasm)
+// +build gopherjs js,wasm
+
+package main
+
+import (
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/link"
+	"gopkg.in/yaml.v2"
+)
+
+// This file contains the functions exposed to the UI which are shared by the
+// GopherJS and the WebAssembly builds. The results are converted to JS
+// objects.
+
+// encodeLink returns an object with the encoded input in the Link field (see
+// link.Encode), or with an Error field.
+func encodeLink(inputYAML string) interface{} {
+	in, err := lib.ParseInput(inputYAML)
+	if err == nil {
+		var s string
+		if s, err = link.Encode(in); err == nil {
+			return map[string]interface{}{"Link": s}
+		}
+	}
+	return map[string]interface{}{"Error": err.Error()}
+}
+
+// decodeLink decodes an input encoded with encodeLink, and splits it in the
+// form used by the UI: the settings that have sliders (in the Sliders field)
+// and the rest of the input, in YAML form (in the Workload field). Config
+// settings without sliders are only included in the workload if they differ
+// from the defaults. On error, the result is an object with an Error field.
+func decodeLink(s string) interface{} {
+	in, err := link.Decode(s)
+	if err != nil {
+		return map[string]interface{}{"Error": err.Error()}
+	}
+	sliders := make(map[string]interface{})
+	for _, f := range lib.ConfigSchema() {
+		if f.Group != "" {
+			sliders[f.Key], _ = in.Config.Get(f.Key)
+		}
+	}
+
+	var tree, defaults yaml.MapSlice
+	text, err := in.YAML()
+	if err == nil {
+		err = yaml.Unmarshal([]byte(text), &tree)
+	}
+	if err == nil {
+		text, err = lib.Input{Config: lib.DefaultConfig}.YAML()
+	}
+	if err == nil {
+		err = yaml.Unmarshal([]byte(text), &defaults)
+	}
+	if err != nil {
+		return map[string]interface{}{"Error": err.Error()}
+	}
+	defaultConfig := make(map[interface{}]interface{})
+	for _, item := range yamlMapValue(defaults, "config") {
+		defaultConfig[item.Key] = item.Value
+	}
+	for i := range tree {
+		if tree[i].Key != "config" {
+			continue
+		}
+		var cfg yaml.MapSlice
+		for _, item := range tree[i].Value.(yaml.MapSlice) {
+			key := item.Key.(string)
+			// Skip the slider settings, along with the durations that
+			// correspond to the slider settings in seconds.
+			_, isSlider := sliders[key]
+			_, isSliderDuration := sliders[key+"_secs"]
+			if isSlider || isSliderDuration || item.Value == defaultConfig[item.Key] {
+				continue
+			}
+			cfg = append(cfg, item)
+		}
+		if len(cfg) == 0 {
+			tree = append(tree[:i:i], tree[i+1:]...)
+		} else {
+			tree[i].Value = cfg
+		}
+		break
+	}
+	out, err := yaml.Marshal(tree)
+	if err != nil {
+		return map[string]interface{}{"Error": err.Error()}
+	}
+	return map[string]interface{}{"Workload": string(out), "Sliders": sliders}
+}
+
+// yamlMapValue returns the value for the given key, if it is a map.
+func yamlMapValue(m yaml.MapSlice, key string) yaml.MapSlice {
+	for _, item := range m {
+		if item.Key == key {
+			v, _ := item.Value.(yaml.MapSlice)
+			return v
+		}
+	}
+	return nil
+}
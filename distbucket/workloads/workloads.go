@@ -0,0 +1,235 @@
+// Package workloads contains the catalog of example workloads, which is
+// embedded in the binaries (and exposed to the UI, the CLI and the HTTP
+// server).
+//
+// Each workload is a YAML file that starts with a front matter section with
+// the metadata, e.g.:
+//
+//	---
+//	title: Ramps
+//	description: |
+//	  Nodes that ramp up at different speeds.
+//	tags: [ramps]
+//	config:
+//	  timeframe: 5m
+//	---
+//	nodes:
+//	  ...
+//
+// The config section contains recommended settings which override the
+// defaults, using the same keys (and values) as the input config. The catalog
+// is loaded when the package is initialized; invalid workloads are left out
+// of it (see Errors), and the package tests fail.
+package workloads
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed *.yaml
+var files embed.FS
+
+// Workload is a workload in the catalog.
+type Workload struct {
+	// Name is the file name, without the extension.
+	Name        string
+	Title       string
+	Description string
+	Tags        []string
+	// Config contains the recommended config settings, by key. Durations that
+	// have an equivalent setting in seconds (see secsKeys) are converted to it.
+	Config map[string]interface{}
+	// Input is the workload, without the front matter.
+	Input string
+}
+
+type frontMatter struct {
+	Title       string
+	Description string
+	Tags        []string
+	Config      map[string]interface{}
+}
+
+const frontMatterDelimiter = "---\n"
+
+var catalog, loadErrors = load()
+
+// All returns all the workloads, sorted by name.
+func All() []Workload {
+	return catalog
+}
+
+// Errors returns the problems with the workloads that were left out of the
+// catalog.
+func Errors() []error {
+	return loadErrors
+}
+
+// Get returns the workload with the given name.
+func Get(name string) (Workload, bool) {
+	for _, w := range catalog {
+		if w.Name == name {
+			return w, true
+		}
+	}
+	return Workload{}, false
+}
+
+// ConfigLines returns the recommended config settings as YAML lines for the
+// config section (without indentation), sorted by key.
+func (w *Workload) ConfigLines() []string {
+	keys := make([]string, 0, len(w.Config))
+	for k := range w.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		out, err := yaml.Marshal(yaml.MapSlice{{Key: k, Value: w.Config[k]}})
+		if err != nil {
+			// The values were unmarshaled from YAML.
+			panic(err)
+		}
+		lines[i] = strings.TrimSuffix(string(out), "\n")
+	}
+	return lines
+}
+
+var configLineRegexp = regexp.MustCompile(`^config:\s*(#.*)?$`)
+
+// InputWithConfig returns the input with the recommended config settings
+// added to the config section (which is created if necessary).
+func (w *Workload) InputWithConfig() string {
+	configLines := w.ConfigLines()
+	if len(configLines) == 0 {
+		return w.Input
+	}
+	for i := range configLines {
+		configLines[i] = "  " + configLines[i]
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Input, "\n"), "\n")
+	for i, l := range lines {
+		if configLineRegexp.MatchString(l) {
+			lines = append(lines[:i+1], append(configLines, lines[i+1:]...)...)
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+	lines = append(lines, "config:")
+	lines = append(lines, configLines...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parse parses a workload file.
+func parse(name, text string) (Workload, error) {
+	if !strings.HasPrefix(text, frontMatterDelimiter) {
+		return Workload{}, fmt.Errorf("missing front matter")
+	}
+	text = text[len(frontMatterDelimiter):]
+	end := strings.Index(text, "\n"+frontMatterDelimiter)
+	if end < 0 {
+		return Workload{}, fmt.Errorf("unterminated front matter")
+	}
+	var fm frontMatter
+	if err := yaml.UnmarshalStrict([]byte(text[:end+1]), &fm); err != nil {
+		return Workload{}, fmt.Errorf("front matter: %v", err)
+	}
+	w := Workload{
+		Name:        name,
+		Title:       fm.Title,
+		Description: strings.TrimSpace(fm.Description),
+		Tags:        fm.Tags,
+		Config:      fm.Config,
+		Input:       text[end+1+len(frontMatterDelimiter):],
+	}
+	if w.Title == "" || w.Description == "" {
+		return Workload{}, fmt.Errorf("front matter: title and description are required")
+	}
+	for key, value := range w.Config {
+		switch value.(type) {
+		case string, int, float64, bool:
+		default:
+			return Workload{}, fmt.Errorf("front matter: invalid value for config setting '%s'", key)
+		}
+		cfg := lib.DefaultConfig
+		setting, err := yaml.Marshal(yaml.MapSlice{{Key: key, Value: value}})
+		if err == nil {
+			err = yaml.UnmarshalStrict(setting, &cfg)
+		}
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			err = fmt.Errorf("%s", strings.TrimPrefix(typeErr.Errors[0], "line 1: "))
+		}
+		if err != nil {
+			return Workload{}, fmt.Errorf("front matter: config setting '%s': %v", key, err)
+		}
+		// Settings that have a slider in seconds are converted, so that the UI
+		// sets the slider.
+		if secsKey, ok := secsKeys[key]; ok {
+			if _, ok := w.Config[secsKey]; ok {
+				return Workload{}, fmt.Errorf("front matter: both '%s' and '%s' are set", key, secsKey)
+			}
+			delete(w.Config, key)
+			w.Config[secsKey], _ = cfg.Get(secsKey)
+		}
+	}
+	return w, nil
+}
+
+// secsKeys maps the config settings that are durations to the equivalent
+// settings in seconds.
+var secsKeys = map[string]string{
+	"backlog_time_scale": "backlog_time_scale_secs",
+}
+
+// validate checks that the workload (with the recommended config) is a valid
+// input, without simulating it.
+func (w *Workload) validate() error {
+	input := w.InputWithConfig()
+	in, err := lib.ParseInput(input)
+	if err != nil {
+		return err
+	}
+	if errs := in.Validate(); errs.HasErrors() {
+		return errs.Filter(lib.SeverityError)
+	}
+	return nil
+}
+
+// load loads the catalog; the workloads that can't be loaded are left out, and
+// the problems are returned.
+func load() ([]Workload, []error) {
+	names, err := files.ReadDir(".")
+	if err != nil {
+		return nil, []error{err}
+	}
+	var res []Workload
+	var errs []error
+	for _, e := range names {
+		data, err := files.ReadFile(e.Name())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), path.Ext(e.Name()))
+		w, err := parse(name, string(data))
+		if err == nil {
+			err = w.validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid workload %s: %v", e.Name(), err))
+			continue
+		}
+		res = append(res, w)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, errs
+}
@@ -0,0 +1,68 @@
+package workloads
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/lib"
+)
+
+// TestWorkloads runs every workload in the catalog (with the recommended
+// config).
+func TestWorkloads(t *testing.T) {
+	for _, err := range Errors() {
+		t.Error(err)
+	}
+	names, err := files.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(All()) != len(names) {
+		t.Errorf("%d files, but %d workloads in the catalog", len(names), len(All()))
+	}
+	for _, w := range All() {
+		t.Run(w.Name, func(t *testing.T) {
+			in, err := lib.ParseInput(w.InputWithConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := lib.Run(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range res.Warnings {
+				t.Errorf("warning: %v", w.Error())
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const nodes = "nodes:\n- terms:\n  - type: constant\n    value: 10\n"
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{
+			input: nodes + "variants:\n- algorithm: foo\n",
+			err:   "variants[0].algorithm: unknown algorithm 'foo' (must be one of: distributed, ideal)",
+		},
+		{
+			input: nodes + "variants:\n- config:\n    tick: 1s\n",
+			err:   "variants[0].config.tick: can't be overridden in a variant",
+		},
+		{
+			input: nodes + "output:\n  charts: [foo]\n",
+			err:   "output.charts[0]: unknown chart 'foo'",
+		},
+	}
+	for _, tc := range testCases {
+		w := Workload{Name: "test", Input: tc.input}
+		err := w.validate()
+		if err == nil {
+			t.Errorf("%q: expected error %q", tc.input, tc.err)
+		} else if actual := err.Error(); !strings.Contains(actual, tc.err) {
+			t.Errorf("%q: expected error %q, got %q", tc.input, tc.err, actual)
+		}
+	}
+}
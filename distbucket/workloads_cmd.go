@@ -0,0 +1,55 @@
+//go:build !js && !gopherjs
+// +build !js,!gopherjs
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RaduBerinde/raduberinde.github.io/distbucket/workloads"
+)
+
+// runWorkloads lists the workload catalog, or prints the input of a workload
+// (with the recommended config settings).
+func runWorkloads(args []string) error {
+	flags := flag.NewFlagSet("workloads", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "show the descriptions and recommended settings")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  distbucket workloads [-v]\n  distbucket workloads <name>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	for _, err := range workloads.Errors() {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		w, ok := workloads.Get(flags.Arg(0))
+		if !ok {
+			return fmt.Errorf("unknown workload '%s'", flags.Arg(0))
+		}
+		fmt.Print(w.InputWithConfig())
+		return nil
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	for _, w := range workloads.All() {
+		fmt.Printf("%-12s %s [%s]\n", w.Name, w.Title, strings.Join(w.Tags, ", "))
+		if *verbose {
+			for _, l := range strings.Split(w.Description, "\n") {
+				fmt.Printf("%-12s   %s\n", "", l)
+			}
+			if lines := w.ConfigLines(); len(lines) > 0 {
+				fmt.Printf("%-12s   recommended config: %s\n", "", strings.Join(lines, ", "))
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}